@@ -28,11 +28,11 @@ func main() {
 	}
 
 	dummyProducts := []models.Product{
-		{Name: "Laptop", Price: 999.99, Stocks: 50},
-		{Name: "Smartphone", Price: 499.99, Stocks: 100},
-		{Name: "Headphones", Price: 99.99, Stocks: 200},
-		{Name: "Tablet", Price: 299.99, Stocks: 75},
-		{Name: "Smartwatch", Price: 199.99, Stocks: 150},
+		{Name: "Laptop", Price: 999.99, Stocks: 50, Status: models.ProductStatusActive},
+		{Name: "Smartphone", Price: 499.99, Stocks: 100, Status: models.ProductStatusActive},
+		{Name: "Headphones", Price: 99.99, Stocks: 200, Status: models.ProductStatusActive},
+		{Name: "Tablet", Price: 299.99, Stocks: 75, Status: models.ProductStatusActive},
+		{Name: "Smartwatch", Price: 199.99, Stocks: 150, Status: models.ProductStatusActive},
 	}
 
 	_, err = collection.InsertMany(ctx, dummyProducts)
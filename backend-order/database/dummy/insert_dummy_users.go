@@ -26,8 +26,10 @@ func main() {
 
 	// Create the admin user
 	adminUser := models.User{
-		Email:   adminEmail,
-		IsAdmin: true,
+		Email:         adminEmail,
+		IsAdmin:       true,
+		Roles:         []string{models.RoleAdmin},
+		EmailVerified: true,
 	}
 
 	// Set the password (this will hash it)
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the API queries rely on. Creating an
+// index that already exists with the same definition is a no-op.
+func EnsureIndexes(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		"products": {
+			{Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}}},
+			{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "stocks", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		"orders": {
+			{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "items.product.id", Value: 1}}},
+			{Keys: bson.D{{Key: "total_amount", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		"refresh_tokens": {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "session_id", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			// Expired tokens are useless, even to detect reuse
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"login_throttles": {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// Throttles are only useful while their failures count
+			{Keys: bson.D{{Key: "last_failure_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60)},
+		},
+		"security_events": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"api_keys": {
+			{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"users": {
+			{Keys: bson.D{{Key: "oidc_issuer", Value: 1}, {Key: "oidc_subject", Value: 1}}},
+		},
+		"oidc_logins": {
+			{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"roles": {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+
+	for name, models := range indexes {
+		collection, err := GetDB().Collection(name).CloneCollection()
+		if err != nil {
+			return err
+		}
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"backend-order/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Marks the users created before email verification existed as verified, so
+// that they can keep placing orders. Users registered since then have the
+// field set and are left untouched.
+func main() {
+	ctx := context.Background()
+	client := database.GetClient()
+	defer client.Close(ctx)
+
+	result, err := database.GetDB().Collection("users").UpdateAll(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		log.Fatalf("Error marking existing users as verified: %v", err)
+	}
+
+	log.Printf("Marked %d existing users as verified", result.ModifiedCount)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend-order/database"
+	"backend-order/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Records an opening balance movement for every product whose inventory
+// ledger doesn't sum up to its current stock: products created before the
+// ledger existed, and products whose stock changed before their first
+// movement was recorded. Running it again records nothing new.
+func main() {
+	ctx := context.Background()
+	client := database.GetClient()
+	defer client.Close(ctx)
+
+	db := database.GetDB()
+
+	var productIDs []primitive.ObjectID
+	if err := db.Collection("products").Find(ctx, bson.M{}).Distinct("_id", &productIDs); err != nil {
+		log.Fatalf("Error listing products: %v", err)
+	}
+
+	recorded := 0
+	for _, productID := range productIDs {
+		// The stock and the ledger are read together, so that an order can't
+		// change one of them in between
+		callback := func(sessCtx context.Context) (interface{}, error) {
+			var product models.Product
+			if err := db.Collection("products").Find(sessCtx, bson.M{"_id": productID}).One(&product); err != nil {
+				return false, err
+			}
+
+			var movements []models.InventoryMovement
+			err := db.Collection("inventory_movements").Find(sessCtx, bson.M{"product_id": productID}).
+				Select(bson.M{"quantity": 1}).All(&movements)
+			if err != nil {
+				return false, err
+			}
+			ledger := 0
+			for _, movement := range movements {
+				ledger += movement.Quantity
+			}
+			if ledger == product.Stocks {
+				return false, nil
+			}
+
+			reason := "Stock before the inventory ledger was introduced"
+			if len(movements) > 0 {
+				reason = "Stock not accounted for by the inventory ledger"
+			}
+			_, err = db.Collection("inventory_movements").InsertOne(sessCtx, models.InventoryMovement{
+				ID:         primitive.NewObjectID(),
+				ProductID:  product.ID,
+				Type:       models.MovementTypeOpeningBalance,
+				Quantity:   product.Stocks - ledger,
+				StockAfter: product.Stocks,
+				Actor:      models.ActorSystem,
+				Reason:     reason,
+				CreatedAt:  time.Now(),
+			})
+			return err == nil, err
+		}
+
+		inserted, err := client.DoTransaction(ctx, callback)
+		if err != nil {
+			log.Fatalf("Error recording opening balance of product %s: %v", productID.Hex(), err)
+		}
+		if inserted.(bool) {
+			recorded++
+		}
+	}
+
+	log.Printf("Recorded opening balances for %d of %d products", recorded, len(productIDs))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"backend-order/database"
+	"backend-order/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Backfills user_id on orders that were created when orders were keyed by
+// the customer's email only. Orders whose email no longer matches a user are
+// left untouched and reported.
+func main() {
+	ctx := context.Background()
+	client := database.GetClient()
+	defer client.Close(ctx)
+
+	db := database.GetDB()
+
+	var emails []string
+	err := db.Collection("orders").Find(ctx, bson.M{"user_id": bson.M{"$exists": false}}).Distinct("customer_id", &emails)
+	if err != nil {
+		log.Fatalf("Error listing orders without user ID: %v", err)
+	}
+
+	migrated := int64(0)
+	for _, email := range emails {
+		var user models.User
+		err := db.Collection("users").Find(ctx, bson.M{"email": email}).One(&user)
+		if err != nil {
+			log.Printf("No user found for orders of %q, skipping: %v", email, err)
+			continue
+		}
+
+		result, err := db.Collection("orders").UpdateAll(ctx,
+			bson.M{"customer_id": email, "user_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"user_id": user.ID}},
+		)
+		if err != nil {
+			log.Fatalf("Error migrating orders of %q: %v", email, err)
+		}
+		migrated += result.ModifiedCount
+	}
+
+	log.Printf("Linked %d orders to their users", migrated)
+}
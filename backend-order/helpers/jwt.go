@@ -0,0 +1,267 @@
+package helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTKey is a key tokens are signed or verified with. SignKey is nil for keys
+// that are only kept to verify tokens issued before a rotation.
+type JWTKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// JWTKeySet holds the configured keys and the one new tokens are signed with
+type JWTKeySet struct {
+	signing *JWTKey
+	keys    map[string]*JWTKey
+}
+
+// JWK is the public part of an asymmetric key as published in a JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the JSON Web Key Set other services verify tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	jwtKeys     *JWTKeySet
+	jwtKeysOnce sync.Once
+)
+
+// JWTKeys returns the keys loaded from the environment, see LoadJWTKeys
+func JWTKeys() *JWTKeySet {
+	jwtKeysOnce.Do(func() {
+		var err error
+		jwtKeys, err = LoadJWTKeys(os.Getenv("JWT_KEYS"), os.Getenv("JWT_SIGNING_KEY_ID"))
+		if err != nil {
+			log.Fatalf("Failed to load JWT keys: %v", err)
+		}
+	})
+	return jwtKeys
+}
+
+// LoadJWTKeys parses a comma separated list of keys in the form kid:ALG:value.
+// For HS256 the value is the secret itself, for RS256 and EdDSA it is the path
+// of a PEM file holding either a private key or, for keys that only verify
+// tokens, a public key. A value of env:NAME reads the secret or the PEM from
+// the NAME environment variable instead, for keys injected from a secret
+// store. signingID selects the key new tokens are signed with and defaults to
+// the first key.
+func LoadJWTKeys(config, signingID string) (*JWTKeySet, error) {
+	set := &JWTKeySet{keys: map[string]*JWTKey{}}
+
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid key %q, expected kid:ALG:value", entry)
+		}
+
+		key, err := parseJWTKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", parts[0], err)
+		}
+		if _, exists := set.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %s", key.ID)
+		}
+		set.keys[key.ID] = key
+		if set.signing == nil && signingID == "" {
+			set.signing = key
+		}
+	}
+
+	if len(set.keys) == 0 {
+		return nil, errors.New("no key configured")
+	}
+	if signingID != "" {
+		set.signing = set.keys[signingID]
+		if set.signing == nil {
+			return nil, fmt.Errorf("signing key %s is not configured", signingID)
+		}
+	}
+	if set.signing.SignKey == nil {
+		return nil, fmt.Errorf("signing key %s has no private key", set.signing.ID)
+	}
+
+	return set, nil
+}
+
+func parseJWTKey(id, alg, value string) (*JWTKey, error) {
+	key := &JWTKey{ID: id, Method: jwt.GetSigningMethod(alg)}
+
+	var contents []byte
+	if name, ok := strings.CutPrefix(value, "env:"); ok {
+		contents = []byte(os.Getenv(name))
+		if len(contents) == 0 {
+			return nil, fmt.Errorf("environment variable %s is empty", name)
+		}
+	}
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		if contents == nil {
+			contents = []byte(value)
+		}
+		key.SignKey = contents
+		key.VerifyKey = key.SignKey
+		return key, nil
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+
+	var err error
+	pem := contents
+	if pem == nil {
+		if pem, err = os.ReadFile(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if alg == jwt.SigningMethodRS256.Alg() {
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+			key.SignKey, key.VerifyKey = private, &private.PublicKey
+			return key, nil
+		}
+		key.VerifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		return key, err
+	}
+
+	if private, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+		key.SignKey, key.VerifyKey = private, private.(crypto.Signer).Public()
+		return key, nil
+	}
+	key.VerifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem)
+	return key, err
+}
+
+// Sign returns a token for the claims signed with the signing key and
+// carrying its kid in the header
+func (s *JWTKeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.SignKey)
+}
+
+// Parse verifies a token with the key named by its kid header. Tokens using
+// another algorithm than their key, or without expiry, are rejected.
+func (s *JWTKeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.VerifyKey, nil
+	}, jwt.WithValidMethods([]string{
+		jwt.SigningMethodHS256.Alg(),
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	}), jwt.WithExpirationRequired())
+}
+
+// JWKS returns the public keys of the set. Symmetric keys are never published.
+func (s *JWTKeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := JWK{Kid: key.ID, Alg: key.Method.Alg(), Use: "sig"}
+		switch public := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// PublicKey returns the key a JWK describes, for keys published by other
+// parties such as identity providers
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testKeySet(t *testing.T) (*JWTKeySet, *rsa.PrivateKey, ed25519.PrivateKey) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := &JWTKey{ID: "hs", Method: jwt.SigningMethodHS256, SignKey: []byte("secret"), VerifyKey: []byte("secret")}
+	set := &JWTKeySet{signing: hs, keys: map[string]*JWTKey{
+		"hs": hs,
+		"rs": {ID: "rs", Method: jwt.SigningMethodRS256, SignKey: rsaKey, VerifyKey: &rsaKey.PublicKey},
+		"ed": {ID: "ed", Method: jwt.SigningMethodEdDSA, SignKey: edKey, VerifyKey: edKey.Public()},
+	}}
+	return set, rsaKey, edKey
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTKeySetParse(t *testing.T) {
+	set, rsaKey, edKey := testKeySet(t)
+	valid := jwt.RegisteredClaims{Subject: "user", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	// The public key of the RS256 key, as an attacker could use it as an HMAC secret
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"HS256 key", signTestToken(t, jwt.SigningMethodHS256, "hs", []byte("secret"), valid), false},
+		{"RS256 key", signTestToken(t, jwt.SigningMethodRS256, "rs", rsaKey, valid), false},
+		{"EdDSA key", signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, valid), false},
+		{"signed by the set", func() string {
+			token, err := set.Sign(valid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}(), false},
+		{"RS256 token naming the HS256 key", signTestToken(t, jwt.SigningMethodRS256, "hs", rsaKey, valid), true},
+		{"HS256 token signed with the RS256 public key", signTestToken(t, jwt.SigningMethodHS256, "rs", publicPEM, valid), true},
+		{"EdDSA token naming the RS256 key", signTestToken(t, jwt.SigningMethodEdDSA, "rs", edKey, valid), true},
+		{"HS384 token naming the HS256 key", signTestToken(t, jwt.SigningMethodHS384, "hs", []byte("secret"), valid), true},
+		{"unsigned token", signTestToken(t, jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, valid), true},
+		{"unknown kid", signTestToken(t, jwt.SigningMethodHS256, "old", []byte("secret"), valid), true},
+		{"missing kid", signTestToken(t, jwt.SigningMethodHS256, "", []byte("secret"), valid), true},
+		{"wrong secret", signTestToken(t, jwt.SigningMethodHS256, "hs", []byte("guess"), valid), true},
+		{"without expiry", signTestToken(t, jwt.SigningMethodHS256, "hs", []byte("secret"), jwt.RegisteredClaims{Subject: "user"}), true},
+		{"expired", signTestToken(t, jwt.SigningMethodHS256, "hs", []byte("secret"), jwt.RegisteredClaims{
+			Subject:   "user",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		}), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims jwt.RegisteredClaims
+			_, err := set.Parse(tt.token, &claims)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && claims.Subject != "user" {
+				t.Errorf("Parse() subject = %q, want user", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestLoadJWTKeysFromEnv(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_JWT_ED_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	t.Setenv("TEST_JWT_HS_KEY", "secret")
+
+	set, err := LoadJWTKeys("ed:EdDSA:env:TEST_JWT_ED_KEY,hs:HS256:env:TEST_JWT_HS_KEY", "ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := set.Sign(jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("token signed with a key from the environment: %v", err)
+	}
+	if string(set.keys["hs"].SignKey.([]byte)) != "secret" {
+		t.Errorf("HS256 secret not read from the environment")
+	}
+
+	if _, err := LoadJWTKeys("ed:EdDSA:env:TEST_JWT_MISSING", ""); err == nil {
+		t.Errorf("expected an error for an unset environment variable")
+	}
+}
@@ -0,0 +1,22 @@
+package helpers
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// FrontendLink returns an absolute link to a page of the frontend, for use
+// in emails. The base URL is read from FRONTEND_URL.
+func FrontendLink(path string, params url.Values) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = "http://localhost:3000" // Default value if not set
+	}
+
+	link := strings.TrimSuffix(base, "/") + path
+	if len(params) > 0 {
+		link += "?" + params.Encode()
+	}
+	return link
+}
@@ -0,0 +1,342 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProvider is an OpenID Connect identity provider users can log in with,
+// using the authorization code flow with PKCE
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the frontend page the provider sends the code back to
+	RedirectURL string
+	Scopes      []string
+
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+	keysAt    time.Time
+}
+
+// oidcDiscovery holds the fields of the provider metadata the flow needs
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCClaims are the claims of an ID token. Raw holds all of them, for
+// provider specific claims such as groups.
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Nonce         string
+	// AMR lists the authentication methods the provider used, such as mfa
+	AMR []string
+	Raw jwt.MapClaims
+}
+
+// oidcKeysRefreshInterval limits how often an unknown kid triggers a fetch
+// of the provider keys
+const oidcKeysRefreshInterval = time.Minute
+
+var (
+	oidcProvider     *OIDCProvider
+	oidcProviderOnce sync.Once
+)
+
+// OIDC returns the provider configured with OIDC_ISSUER, OIDC_CLIENT_ID,
+// OIDC_CLIENT_SECRET and OIDC_REDIRECT_URL, or nil when single sign-on is not
+// configured. The provider metadata is fetched on first use, so an
+// unreachable provider doesn't prevent the service from starting.
+func OIDC() *OIDCProvider {
+	oidcProviderOnce.Do(func() {
+		issuer := os.Getenv("OIDC_ISSUER")
+		if issuer == "" {
+			return
+		}
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			log.Fatal("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+		}
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = FrontendLink("/sso/callback", nil)
+		}
+		scopes := []string{"openid", "email", "profile"}
+		if extra := os.Getenv("OIDC_SCOPES"); extra != "" {
+			scopes = append(scopes, strings.Split(extra, " ")...)
+		}
+		oidcProvider = &OIDCProvider{
+			Issuer:       strings.TrimSuffix(issuer, "/"),
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+			client:       &http.Client{Timeout: 10 * time.Second},
+		}
+	})
+	return oidcProvider
+}
+
+// NewPKCEVerifier returns a random code verifier and its S256 challenge
+func NewPKCEVerifier() (verifier, challenge string, err error) {
+	verifier, err = RandomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomURLToken returns 32 random bytes encoded for use in URLs
+func RandomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the provider page to send the user to
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the verified
+// claims of the ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (OIDCClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.ClientSecret == "" {
+		// Public clients only identify themselves
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return OIDCClaims{}, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return OIDCClaims{}, err
+	}
+	if tokens.IDToken == "" {
+		return OIDCClaims{}, errors.New("token response has no id_token")
+	}
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, idToken, nonce string) (OIDCClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		// Tokens carry the issuer exactly as published
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	result := OIDCClaims{Raw: claims}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Nonce, _ = claims["nonce"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		// Some providers send booleans as strings
+		result.EmailVerified = verified == "true"
+	}
+	result.AMR = ClaimStrings(claims, "amr")
+
+	if result.Subject == "" {
+		return OIDCClaims{}, errors.New("id token has no subject")
+	}
+	if nonce == "" || result.Nonce != nonce {
+		return OIDCClaims{}, errors.New("id token nonce mismatch")
+	}
+	return result, nil
+}
+
+// ClaimStrings returns a claim holding a list of strings, or a single string
+func ClaimStrings(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// discover fetches the provider metadata once
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q doesn't match %q", discovery.Issuer, p.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// key returns the provider key named kid, fetching the provider keys again
+// when it is unknown, since providers rotate them
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysAt) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	var set JWKS
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc keys: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping OIDC key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
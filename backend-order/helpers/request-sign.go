@@ -17,8 +17,15 @@ const (
 
 var secretKey = []byte(os.Getenv("API_SECRET_KEY"))
 
-func SignRequest(method, path string, body []byte, timestamp time.Time) string {
+// SignRequest signs a request to another service. The Authorization header,
+// when the request forwards one, is signed too, so that a captured request
+// can't be replayed with the credentials of someone else.
+func SignRequest(method, path string, body []byte, timestamp time.Time, authorization string) string {
 	message := fmt.Sprintf("%s%s%s%d", method, path, body, timestamp.Unix())
+	if authorization != "" {
+		hash := sha256.Sum256([]byte(authorization))
+		message += hex.EncodeToString(hash[:])
+	}
 	h := hmac.New(sha256.New, secretKey)
 	h.Write([]byte(message))
 	return hex.EncodeToString(h.Sum(nil))
@@ -42,6 +49,6 @@ func VerifySignature(r *http.Request, body []byte) bool {
 		return false
 	}
 
-	expectedSignature := SignRequest(r.Method, r.URL.Path, body, t)
+	expectedSignature := SignRequest(r.Method, r.URL.Path, body, t, r.Header.Get("Authorization"))
 	return hmac.Equal([]byte(signature), []byte(expectedSignature))
 }
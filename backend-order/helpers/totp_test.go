@@ -0,0 +1,45 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	// The RFC gives 8 digit codes, the last 6 are the 6 digit code
+	at59 := time.Unix(59, 0)
+	at1111111109 := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"RFC 6238 vector at 59", rfcSecret, "287082", at59, 1, true},
+		{"RFC 6238 vector at 1111111109", rfcSecret, "081804", at1111111109, 37037036, true},
+		{"previous period within the skew", rfcSecret, "287082", at59.Add(TOTPPeriod), 1, true},
+		{"next period within the skew", rfcSecret, "287082", at59.Add(-TOTPPeriod), 1, true},
+		{"two periods late", rfcSecret, "287082", at59.Add(2 * TOTPPeriod), 0, false},
+		{"two periods early", rfcSecret, "081804", at1111111109.Add(-2 * TOTPPeriod), 0, false},
+		{"wrong code", rfcSecret, "287083", at59, 0, false},
+		{"too short", rfcSecret, "28708", at59, 0, false},
+		{"too long", rfcSecret, "94287082", at59, 0, false},
+		{"lowercase secret with spaces", " gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", "287082", at59, 1, true},
+		{"invalid secret", "not base32!", "287082", at59, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, tt.at)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP() = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
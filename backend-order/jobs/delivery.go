@@ -18,30 +18,35 @@ func DeliverConfirmedOrders() {
 
 	now := time.Now()
 
-	// Find orders that are in "Confirmed" status and older than 60 seconds
-	filter := bson.M{
-		"status":     models.OrderStatusConfirmed,
-		"updated_at": bson.M{"$lt": now.Add(-60 * time.Second)},
-	}
-
-	update := bson.M{
-		"$set": bson.M{
-			"status":     models.OrderStatusDelivered,
-			"updated_at": now,
-		},
-		"$push": bson.M{
-			"timeline": models.TimelineEvent{
-				Name:      "Delivered",
-				Timestamp: now,
-			},
+	// Ship orders that are in "Confirmed" status and older than 60 seconds
+	shipped, err := models.TransitionOrders(ctx, collection,
+		bson.M{"updated_at": bson.M{"$lt": now.Add(-60 * time.Second)}},
+		models.OrderStatusConfirmed,
+		models.OrderTransition{
+			To:     models.OrderStatusShipped,
+			Actor:  models.ActorSystem,
+			Reason: "Dispatched by delivery job",
 		},
+	)
+	if err != nil {
+		log.Printf("Error shipping confirmed orders: %v", err)
+		return
 	}
 
-	result, err := collection.UpdateAll(ctx, filter, update)
+	// Deliver orders that are in "Shipped" status and older than 60 seconds
+	delivered, err := models.TransitionOrders(ctx, collection,
+		bson.M{"updated_at": bson.M{"$lt": now.Add(-60 * time.Second)}},
+		models.OrderStatusShipped,
+		models.OrderTransition{
+			To:     models.OrderStatusDelivered,
+			Actor:  models.ActorSystem,
+			Reason: "Delivered by delivery job",
+		},
+	)
 	if err != nil {
-		log.Printf("Error delivering confirmed orders: %v", err)
+		log.Printf("Error delivering shipped orders: %v", err)
 		return
 	}
 
-	log.Printf("Delivered %d confirmed orders", result.ModifiedCount)
+	log.Printf("Shipped %d confirmed orders, delivered %d shipped orders", shipped, delivered)
 }
@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"backend-order/database"
+	"backend-order/models"
+)
+
+const defaultPaymentWindow = 30 * time.Minute
+
+// ExpireUnpaidOrders cancels orders left in "Created" status for longer than
+// the payment window and releases their stock. Orders in "PaymentPending"
+// are being charged and are left alone, backend-payment moves them back to
+// "Created" if the payment fails.
+func ExpireUnpaidOrders() {
+	ctx := context.Background()
+	db := database.GetDB()
+
+	window := paymentWindow()
+	filter := bson.M{
+		"status":     models.OrderStatusCreated,
+		"created_at": bson.M{"$lt": time.Now().Add(-window)},
+	}
+
+	var orders []models.Order
+	err := db.Collection("orders").Find(ctx, filter).All(&orders)
+	if err != nil {
+		log.Printf("Error fetching unpaid orders: %v", err)
+		return
+	}
+
+	expired := 0
+	for _, found := range orders {
+		callback := func(sessCtx context.Context) (interface{}, error) {
+			// A retried callback must start from the stored order, not from
+			// the one changed by the attempt that was rolled back
+			var order models.Order
+			if err := db.Collection("orders").Find(sessCtx, bson.M{"_id": found.ID}).One(&order); err != nil {
+				return nil, err
+			}
+			if order.Status != models.OrderStatusCreated {
+				return nil, models.ErrOrderStatusConflict
+			}
+
+			reason := fmt.Sprintf("Not paid within %s", window)
+			err := models.TransitionOrder(sessCtx, db.Collection("orders"), &order, models.OrderTransition{
+				To:     models.OrderStatusCancelled,
+				Actor:  models.ActorSystem,
+				Event:  "Expired",
+				Reason: reason,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, order.RestoreStock(sessCtx, db, models.ActorSystem, reason)
+		}
+
+		if _, err := database.GetClient().DoTransaction(ctx, callback); err != nil {
+			// The order may have been paid or cancelled meanwhile, it is retried on the next run otherwise
+			log.Printf("Error expiring order [%s]: %v", found.ID.Hex(), err)
+			continue
+		}
+		expired++
+	}
+
+	log.Printf("Expired %d unpaid orders", expired)
+}
+
+// paymentWindow reads ORDER_PAYMENT_WINDOW, e.g. "30m" or "2h"
+func paymentWindow() time.Duration {
+	value := os.Getenv("ORDER_PAYMENT_WINDOW")
+	if value == "" {
+		return defaultPaymentWindow
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		log.Printf("Invalid ORDER_PAYMENT_WINDOW %q, using %s", value, defaultPaymentWindow)
+		return defaultPaymentWindow
+	}
+	return window
+}
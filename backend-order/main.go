@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/url"
 	"os"
@@ -12,10 +13,12 @@ import (
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
+	"backend-order/database"
 	docs "backend-order/docs"
+	"backend-order/helpers"
 	"backend-order/jobs"
 	"backend-order/middleware"
-	_ "backend-order/models"
+	"backend-order/models"
 	"backend-order/routes"
 )
 
@@ -35,8 +38,29 @@ func main() {
 		log.Println("Error loading .env file, using environment variables")
 	}
 
+	// Fail at startup rather than on the first login when keys are misconfigured
+	helpers.JWTKeys()
+
+	if err := database.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Error creating database indexes: %v", err)
+	}
+
+	if err := models.EnsureBuiltInRoles(context.Background(), database.GetDB().Collection("roles")); err != nil {
+		log.Printf("Error creating built-in roles: %v", err)
+	}
+
 	r := gin.Default()
 
+	// Client IPs are used to throttle logins, so only trust X-Forwarded-For
+	// from the configured proxies, e.g. the load balancer subnets
+	var trustedProxies []string
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	r.Use(middleware.LoggerMiddleware())
 
 	// Setup routes
@@ -83,6 +107,7 @@ func runBackgroundJob() {
 		select {
 		case <-ticker.C:
 			jobs.DeliverConfirmedOrders()
+			jobs.ExpireUnpaidOrders()
 		}
 	}
 }
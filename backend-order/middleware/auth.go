@@ -2,9 +2,13 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"backend-order/database"
+	"backend-order/helpers"
 	"backend-order/models"
 
 	"github.com/gin-gonic/gin"
@@ -13,35 +17,41 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var secretKey = []byte("your_secret_key") // TODO: Use environment variable in production
-
+// AuthMiddleware authenticates the request with the access token or the
+// personal API key of the Authorization header, and stores the user in the
+// context
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization token"})
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
-		})
+		if models.IsAPIKey(tokenString) {
+			apiKeyAuth(c, tokenString)
+			return
+		}
 
+		claims := jwt.MapClaims{}
+		token, err := helpers.JWTKeys().Parse(tokenString, claims)
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
+		// Tokens issued for another purpose, such as email verification, aren't access tokens
+		_, hasPurpose := claims["purpose"]
+		id, ok := claims["id"].(string)
+		if !ok || hasPurpose {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			c.Abort()
 			return
 		}
 
-		userID, err := primitive.ObjectIDFromHex(claims["id"].(string))
+		userID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
 			c.Abort()
@@ -56,28 +66,210 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if user.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+			c.Abort()
+			return
+		}
+
+		// Tokens issued before a logout from all sessions carry an older version
+		version, _ := claims["ver"].(float64)
+		if int(version) != user.TokenVersion {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		sid, _ := claims["sid"].(string)
+		sessionID, err := primitive.ObjectIDFromHex(sid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session in token"})
+			c.Abort()
+			return
+		}
+		revoked, err := models.IsSessionRevoked(context.Background(), db.Collection("refresh_tokens"), sessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking session"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
 
 		c.Set("user", user)
+		c.Set("session_id", sessionID)
+		c.Set("mfa", claims["mfa"] == true)
+		c.Next()
+	}
+}
+
+// apiKeyAuth authenticates the request with a personal API key instead of an
+// access token. The key is stored in the context as "api_key".
+func apiKeyAuth(c *gin.Context, secret string) {
+	ctx := context.Background()
+	db := database.GetDB()
+	keys := db.Collection("api_keys")
+
+	key, err := models.FindActiveAPIKey(ctx, keys, secret)
+	if err == models.ErrInvalidAPIKey {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking API key"})
+		c.Abort()
+		return
+	}
+
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": key.UserID}).One(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		c.Abort()
+		return
+	}
+
+	if err := models.TouchAPIKey(ctx, keys, key.ID, c.ClientIP()); err != nil {
+		log.Printf("Failed to record use of API key %s: %v", key.ID.Hex(), err)
+	}
+
+	c.Set("user", user)
+	c.Set("api_key", key)
+	c.Set("mfa", key.MFA)
+	c.Next()
+}
+
+// CurrentAPIKey returns the API key the request was authenticated with, if any
+func CurrentAPIKey(c *gin.Context) (models.APIKey, bool) {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return models.APIKey{}, false
+	}
+	key, ok := value.(models.APIKey)
+	return key, ok
+}
+
+// RequireScope rejects requests authenticated with an API key that doesn't
+// grant the scope. Access tokens are not scoped. It must run after
+// AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key, ok := CurrentAPIKey(c); ok && !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key scope " + scope + " required"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
-func AdminOnly() gin.HandlerFunc {
+// SessionOnly rejects requests authenticated with an API key, for endpoints
+// managing the account itself such as its credentials. It must run after
+// AuthMiddleware.
+func SessionOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		if _, ok := CurrentAPIKey(c); ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed with an API key, log in instead"})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user stored by AuthMiddleware. If it is missing,
+// an error response is written and ok is false.
+func CurrentUser(c *gin.Context) (user models.User, ok bool) {
+	value, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return models.User{}, false
+	}
+	user, ok = value.(models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user data"})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// RequireVerifiedEmail rejects users whose email is not verified, unless
+// REQUIRE_EMAIL_VERIFICATION is false. It must run after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	required := os.Getenv("REQUIRE_EMAIL_VERIFICATION") != "false"
+
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		if required && !user.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AdminMFARequired tells whether access to the admin API requires a session
+// opened with two-factor authentication. It can be turned off by setting
+// ADMIN_MFA_REQUIRED to false.
+func AdminMFARequired() bool {
+	return os.Getenv("ADMIN_MFA_REQUIRED") != "false"
+}
+
+// RequirePermission only lets through users whose roles grant every given
+// permission, from a session opened with two-factor authentication unless
+// AdminMFARequired is off. API keys must also have the permissions as
+// scopes. It must run after AuthMiddleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		granted, err := models.ResolvePermissions(context.Background(), database.GetDB().Collection("roles"), user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving permissions"})
+			c.Abort()
+			return
+		}
+
+		key, isAPIKey := CurrentAPIKey(c)
+		for _, permission := range permissions {
+			if !granted[permission] {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + permission + " required"})
+				c.Abort()
+				return
+			}
+			if isAPIKey && !key.HasScope(permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API key scope " + permission + " required"})
+				c.Abort()
+				return
+			}
+		}
 
-		userModel, ok := user.(models.User)
-		if !ok || !userModel.IsAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if AdminMFARequired() && !c.GetBool("mfa") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for admin access, enroll at /auth/mfa/enroll"})
 			c.Abort()
 			return
 		}
 
+		c.Set("permissions", granted)
 		c.Next()
 	}
 }
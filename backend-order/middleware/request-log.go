@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -33,6 +34,22 @@ type logEntry struct {
 	ClientIP       string            `json:"client_ip"`
 }
 
+// Headers carrying credentials, logged as [redacted]
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// Paths whose bodies hold passwords, tokens, MFA secrets or API keys. Only
+// their headers and status are logged.
+var unloggedBodyPaths = []string{"/auth/", "/api-keys", "/backend/auth/"}
+
+func logsBody(path string) bool {
+	for _, prefix := range unloggedBodyPaths {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
 func LoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -66,6 +83,11 @@ func LoggerMiddleware() gin.HandlerFunc {
 			ClientIP:       c.ClientIP(),
 		}
 
+		if !logsBody(entry.Path) {
+			entry.RequestBody = "[redacted]"
+			entry.ResponseBody = "[redacted]"
+		}
+
 		// Copy headers (converting []string to string)
 		for k, v := range c.Request.Header {
 			entry.RequestHeader[k] = v[0]
@@ -73,6 +95,15 @@ func LoggerMiddleware() gin.HandlerFunc {
 		for k, v := range c.Writer.Header() {
 			entry.ResponseHeader[k] = v[0]
 		}
+		// Credentials must not end up in the logs
+		for _, k := range redactedHeaders {
+			if _, ok := entry.RequestHeader[k]; ok {
+				entry.RequestHeader[k] = "[redacted]"
+			}
+			if _, ok := entry.ResponseHeader[k]; ok {
+				entry.ResponseHeader[k] = "[redacted]"
+			}
+		}
 
 		// Copy query parameters
 		for k, v := range c.Request.URL.Query() {
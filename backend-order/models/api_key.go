@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyPrefix starts every API key, so they can be told apart from access
+// tokens and spotted by secret scanners
+const APIKeyPrefix = "oak_"
+
+const (
+	// APIKeyDefaultLifetime applies when no expiry is given at creation
+	APIKeyDefaultLifetime = 90 * 24 * time.Hour
+	APIKeyMaxLifetime     = 365 * 24 * time.Hour
+	// apiKeyUsageInterval limits how often the last use of a key is written
+	apiKeyUsageInterval = time.Minute
+)
+
+// UserScopes can be granted to any API key. They cover the resources of the
+// key owner, such as their cart and orders. Other scopes are permissions,
+// which the roles of the owner must grant.
+var UserScopes = []string{PermissionOrdersRead, PermissionOrdersWrite}
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKey is a long-lived credential of a user for machine clients. Only the
+// hash of the key is stored; Prefix is kept to recognize it in lists.
+type APIKey struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID  primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name    string             `json:"name" bson:"name"`
+	Prefix  string             `json:"prefix" bson:"prefix"`
+	KeyHash string             `json:"-" bson:"key_hash"`
+	// Scopes limit what the key can do, on top of the permissions of the user
+	Scopes []string `json:"scopes" bson:"scopes"`
+	// MFA tells whether the key was created from a session opened with a
+	// second factor, which admin scopes require
+	MFA        bool       `json:"mfa" bson:"mfa"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" bson:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty" bson:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// IsAPIKey tells whether a credential looks like an API key rather than an
+// access token
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// HasScope tells whether the key grants the scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUserScope tells whether the scope can be granted without a permission
+func IsUserScope(scope string) bool {
+	for _, s := range UserScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey stores a new API key and returns it in clear. The key can't be
+// retrieved afterwards.
+func CreateAPIKey(ctx context.Context, keys *qmgo.Collection, key APIKey) (string, APIKey, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", APIKey{}, err
+	}
+	secret := APIKeyPrefix + hex.EncodeToString(b)
+
+	key.ID = primitive.NewObjectID()
+	key.Prefix = secret[:len(APIKeyPrefix)+8]
+	key.KeyHash = HashToken(secret)
+	key.CreatedAt = time.Now()
+	if _, err := keys.InsertOne(ctx, key); err != nil {
+		return "", APIKey{}, err
+	}
+	return secret, key, nil
+}
+
+// FindActiveAPIKey returns the key matching the secret if it is neither
+// revoked nor expired
+func FindActiveAPIKey(ctx context.Context, keys *qmgo.Collection, secret string) (APIKey, error) {
+	var key APIKey
+	err := keys.Find(ctx, bson.M{
+		"key_hash":   HashToken(secret),
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).One(&key)
+	if err == qmgo.ErrNoSuchDocuments {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	return key, err
+}
+
+// TouchAPIKey records the use of a key. It writes at most once a minute per
+// key so that busy clients don't turn every request into a write.
+func TouchAPIKey(ctx context.Context, keys *qmgo.Collection, keyID primitive.ObjectID, ip string) error {
+	now := time.Now()
+	err := keys.UpdateOne(ctx,
+		bson.M{"_id": keyID, "last_used_at": bson.M{"$not": bson.M{"$gte": now.Add(-apiKeyUsageInterval)}}},
+		bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": ip}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return nil
+	}
+	return err
+}
+
+// RevokeUserAPIKeys revokes every key of the user
+func RevokeUserAPIKeys(ctx context.Context, keys *qmgo.Collection, userID primitive.ObjectID) error {
+	_, err := keys.UpdateAll(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// RevokeAPIKey revokes a key of the user. It returns ErrInvalidAPIKey when
+// the user has no such key.
+func RevokeAPIKey(ctx context.Context, keys *qmgo.Collection, userID, keyID primitive.ObjectID) error {
+	err := keys.UpdateOne(ctx,
+		bson.M{"_id": keyID, "user_id": userID},
+		// $min keeps the first revocation time when revoked twice
+		bson.M{"$min": bson.M{"revoked_at": time.Now()}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrInvalidAPIKey
+	}
+	return err
+}
+
+// ListAPIKeys returns the keys of the user, newest first
+func ListAPIKeys(ctx context.Context, keys *qmgo.Collection, userID primitive.ObjectID) ([]APIKey, error) {
+	list := []APIKey{}
+	err := keys.Find(ctx, bson.M{"user_id": userID}).Sort("-created_at").All(&list)
+	return list, err
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CartItem is a product and quantity the user intends to buy
+type CartItem struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+}
+
+// Cart is the persisted shopping cart of a user, one per user
+type Cart struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Items     []CartItem         `json:"items" bson:"items"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MovementTypeOpeningBalance = "opening_balance"
+	MovementTypeSale           = "sale"
+	MovementTypeCancelRestore  = "cancel_restore"
+	MovementTypeAdjustment     = "adjustment"
+	MovementTypeRestock        = "restock"
+	MovementTypeReturn         = "return"
+)
+
+// ErrInsufficientStock is returned when a movement would take the stock below zero
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// InventoryMovement is one entry of the stock ledger. The quantities of all
+// movements of a product add up to its current stock.
+type InventoryMovement struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductID  primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Type       string             `json:"type" bson:"type"`
+	Quantity   int                `json:"quantity" bson:"quantity"`
+	StockAfter int                `json:"stock_after" bson:"stock_after"`
+	OrderID    string             `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Actor      string             `json:"actor" bson:"actor"`
+	Reason     string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// MoveStock changes the stock of movement.ProductID by movement.Quantity and
+// records the movement. Call it inside a transaction so the stock and the
+// ledger can't drift apart.
+func MoveStock(ctx context.Context, db *qmgo.Database, movement InventoryMovement) (InventoryMovement, error) {
+	filter := bson.M{"_id": movement.ProductID}
+	if movement.Quantity < 0 {
+		filter["stocks"] = bson.M{"$gte": -movement.Quantity}
+	}
+
+	var product Product
+	change := qmgo.Change{
+		Update:    bson.M{"$inc": bson.M{"stocks": movement.Quantity}},
+		ReturnNew: true,
+	}
+	if err := db.Collection("products").Find(ctx, filter).Apply(change, &product); err != nil {
+		if err == qmgo.ErrNoSuchDocuments && movement.Quantity < 0 {
+			// Either the product is gone or there isn't enough stock
+			count, countErr := db.Collection("products").Find(ctx, bson.M{"_id": movement.ProductID}).Count()
+			if countErr == nil && count > 0 {
+				return movement, ErrInsufficientStock
+			}
+		}
+		return movement, err
+	}
+
+	movement.ID = primitive.NewObjectID()
+	movement.StockAfter = product.Stocks
+	if movement.CreatedAt.IsZero() {
+		movement.CreatedAt = time.Now()
+	}
+
+	if _, err := db.Collection("inventory_movements").InsertOne(ctx, movement); err != nil {
+		return movement, err
+	}
+	return movement, nil
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginThrottlePolicy decides how failed logins slow down and lock a key
+type LoginThrottlePolicy struct {
+	// DelayAfter failures are allowed before each attempt has to wait
+	DelayAfter int
+	// MaxDelay caps the wait, which doubles with every failure
+	MaxDelay  time.Duration
+	LockAfter int
+	LockFor   time.Duration
+	// Failures older than Window are forgotten
+	Window time.Duration
+}
+
+var (
+	AccountThrottlePolicy = LoginThrottlePolicy{
+		DelayAfter: 3,
+		MaxDelay:   30 * time.Second,
+		LockAfter:  10,
+		LockFor:    15 * time.Minute,
+		Window:     15 * time.Minute,
+	}
+	IPThrottlePolicy = LoginThrottlePolicy{
+		DelayAfter: 10,
+		MaxDelay:   30 * time.Second,
+		LockAfter:  50,
+		LockFor:    15 * time.Minute,
+		Window:     15 * time.Minute,
+	}
+	// Verification email requests are all counted, so that an inbox can't
+	// be flooded with them
+	VerificationAccountPolicy = LoginThrottlePolicy{
+		DelayAfter: 2,
+		MaxDelay:   5 * time.Minute,
+		LockAfter:  5,
+		LockFor:    time.Hour,
+		Window:     time.Hour,
+	}
+	VerificationIPPolicy = LoginThrottlePolicy{
+		DelayAfter: 5,
+		MaxDelay:   5 * time.Minute,
+		LockAfter:  20,
+		LockFor:    time.Hour,
+		Window:     time.Hour,
+	}
+)
+
+// LoginThrottle counts the recent failed logins of an account or an IP
+type LoginThrottle struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`
+	Failures      int                `json:"failures" bson:"failures"`
+	LastFailureAt time.Time          `json:"last_failure_at" bson:"last_failure_at"`
+	LockedUntil   *time.Time         `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+}
+
+// AccountThrottleKey returns the throttle key of an email, whether or not an
+// account exists for it
+func AccountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPThrottleKey returns the throttle key of a client IP
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// VerificationThrottleKey returns the throttle key of the verification emails
+// requested for an email, counted apart from its logins
+func VerificationThrottleKey(email string) string {
+	return "verification:" + AccountThrottleKey(email)
+}
+
+// VerificationIPThrottleKey returns the throttle key of the verification
+// emails requested from a client IP
+func VerificationIPThrottleKey(ip string) string {
+	return "verification:" + IPThrottleKey(ip)
+}
+
+// RetryAfter returns how long the key has to wait before its next attempt
+func (t LoginThrottle) RetryAfter(policy LoginThrottlePolicy, now time.Time) time.Duration {
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		return t.LockedUntil.Sub(now)
+	}
+	if t.Failures < policy.DelayAfter || now.Sub(t.LastFailureAt) > policy.Window {
+		return 0
+	}
+
+	delay := policy.MaxDelay
+	if shift := t.Failures - policy.DelayAfter; shift < 16 {
+		delay = min(time.Second<<shift, policy.MaxDelay)
+	}
+	if wait := t.LastFailureAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// loginAttemptRetries bounds how often a contended attempt is counted again
+const loginAttemptRetries = 5
+
+// CountLoginAttempt counts an attempt of the key as a failure before its
+// credentials are checked, so that parallel attempts can't all get through
+// before the first failure is recorded. It returns how long the key has to
+// wait when the attempt isn't allowed, which isn't counted then, and locked
+// is true when this attempt locked the key. Attempts that succeed are taken
+// back with ResetLoginFailures or ForgiveLoginAttempt.
+func CountLoginAttempt(ctx context.Context, throttles *qmgo.Collection, key string, policy LoginThrottlePolicy) (wait time.Duration, locked bool, err error) {
+	for i := 0; i < loginAttemptRetries; i++ {
+		now := time.Now()
+
+		var throttle LoginThrottle
+		err := throttles.Find(ctx, bson.M{"key": key}).One(&throttle)
+		if err == qmgo.ErrNoSuchDocuments {
+			_, err = throttles.InsertOne(ctx, LoginThrottle{Key: key, Failures: 1, LastFailureAt: now})
+			if qmgo.IsDup(err) {
+				continue
+			}
+			return 0, false, err
+		}
+		if err != nil {
+			return 0, false, err
+		}
+
+		if wait := throttle.RetryAfter(policy, now); wait > 0 {
+			return wait, false, nil
+		}
+
+		failures := throttle.Failures + 1
+		update := bson.M{}
+		if now.Sub(throttle.LastFailureAt) > policy.Window {
+			// The previous failures are too old to count
+			failures = 1
+			update["$unset"] = bson.M{"locked_until": ""}
+		}
+		set := bson.M{"failures": failures, "last_failure_at": now}
+		locked = failures >= policy.LockAfter
+		if locked {
+			set["locked_until"] = now.Add(policy.LockFor)
+			delete(update, "$unset")
+		}
+		update["$set"] = set
+
+		// Only one of concurrent attempts reading the same count is counted
+		err = throttles.UpdateOne(ctx, bson.M{
+			"_id":             throttle.ID,
+			"failures":        throttle.Failures,
+			"last_failure_at": throttle.LastFailureAt,
+		}, update)
+		if err == qmgo.ErrNoSuchDocuments {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, locked, nil
+	}
+
+	// Too many attempts at once
+	return time.Second, false, nil
+}
+
+// ForgiveLoginAttempt takes back an attempt counted for the key that succeeded
+func ForgiveLoginAttempt(ctx context.Context, throttles *qmgo.Collection, key string) error {
+	err := throttles.UpdateOne(ctx, bson.M{"key": key, "failures": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"failures": -1}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return nil
+	}
+	return err
+}
+
+// ResetLoginFailures forgets the failures and lock of the key
+func ResetLoginFailures(ctx context.Context, throttles *qmgo.Collection, key string) error {
+	_, err := throttles.RemoveAll(ctx, bson.M{"key": key})
+	return err
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"backend-order/helpers"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated at once
+const RecoveryCodeCount = 10
+
+var ErrInvalidMFACode = errors.New("invalid two-factor code")
+
+// recoveryCodeAlphabet leaves out characters that are easily confused
+const recoveryCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateRecoveryCodes returns new recovery codes in clear, formatted as
+// XXXXX-XXXXX, and their hashes to store
+func GenerateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[int(b[j])%len(recoveryCodeAlphabet)]
+		}
+		code := string(b[:5]) + "-" + string(b[5:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return HashToken(normalized)
+}
+
+// VerifyTOTP checks a code against the enabled secret of the user and marks
+// its time step as used, so that the same code can't be replayed
+func VerifyTOTP(ctx context.Context, users *qmgo.Collection, user User, code string) error {
+	step, ok := user.totpStep(code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	err := users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "mfa_last_step": bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{"mfa_last_step": step}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrInvalidMFACode
+	}
+	return err
+}
+
+// totpStep returns the time step of a code of the enabled secret of the
+// user at t, refusing steps that were already used. The update of VerifyTOTP
+// refuses them again for concurrent logins.
+func (u User) totpStep(code string, t time.Time) (int64, bool) {
+	step, ok := helpers.ValidateTOTP(u.MFASecret, code, t)
+	if !ok || !u.MFAEnabled || step <= u.MFALastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+// UseRecoveryCode consumes one of the recovery codes of the user
+func UseRecoveryCode(ctx context.Context, users *qmgo.Collection, user User, code string) error {
+	hash := hashRecoveryCode(code)
+	err := users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "mfa_enabled": true, "mfa_recovery_codes": hash},
+		bson.M{"$pull": bson.M{"mfa_recovery_codes": hash}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrInvalidMFACode
+	}
+	return err
+}
+
+// DisableMFA removes the second factor of a user, including an unfinished
+// enrollment and the recovery codes
+func DisableMFA(ctx context.Context, users *qmgo.Collection, userID primitive.ObjectID) error {
+	return users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set":   bson.M{"mfa_enabled": false},
+		"$unset": bson.M{"mfa_secret": "", "mfa_pending_secret": "", "mfa_last_step": "", "mfa_recovery_codes": ""},
+	})
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPStep(t *testing.T) {
+	// The RFC 6238 SHA1 secret, whose code at 59s is 287082 in time step 1
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	at59 := time.Unix(59, 0)
+
+	tests := []struct {
+		name     string
+		user     User
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"valid code", User{MFAEnabled: true, MFASecret: secret}, "287082", at59, 1, true},
+		{"code of the previous period", User{MFAEnabled: true, MFASecret: secret}, "287082", at59.Add(30 * time.Second), 1, true},
+		{"code outside the window", User{MFAEnabled: true, MFASecret: secret}, "287082", at59.Add(90 * time.Second), 0, false},
+		{"replayed step", User{MFAEnabled: true, MFASecret: secret, MFALastStep: 1}, "287082", at59, 0, false},
+		{"step older than the last used", User{MFAEnabled: true, MFASecret: secret, MFALastStep: 2}, "287082", at59.Add(30 * time.Second), 0, false},
+		{"step after the last used", User{MFAEnabled: true, MFASecret: secret, MFALastStep: 1}, "081804", time.Unix(1111111109, 0), 37037036, true},
+		{"two-factor authentication disabled", User{MFASecret: secret}, "287082", at59, 0, false},
+		{"pending secret only", User{MFAPendingSecret: secret}, "287082", at59, 0, false},
+		{"wrong code", User{MFAEnabled: true, MFASecret: secret}, "000000", at59, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := tt.user.totpStep(tt.code, tt.at)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("totpStep() = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCLoginLifetime is how long a user has to log in at the identity provider
+const OIDCLoginLifetime = 10 * time.Minute
+
+var ErrInvalidOIDCState = errors.New("invalid or expired sso state")
+
+// OIDCLogin keeps the secrets of a single sign-on login in progress, between
+// the redirect to the identity provider and its callback. It is looked up by
+// the hash of the state parameter.
+type OIDCLogin struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash    string             `bson:"state_hash"`
+	CodeVerifier string             `bson:"code_verifier"`
+	Nonce        string             `bson:"nonce"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at"`
+}
+
+// StartOIDCLogin stores the secrets of a login for the state
+func StartOIDCLogin(ctx context.Context, logins *qmgo.Collection, state, codeVerifier, nonce string) error {
+	now := time.Now()
+	_, err := logins.InsertOne(ctx, OIDCLogin{
+		StateHash:    HashToken(state),
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(OIDCLoginLifetime),
+	})
+	return err
+}
+
+// ConsumeOIDCLogin removes and returns the login of the state, so that a
+// callback can't be replayed
+func ConsumeOIDCLogin(ctx context.Context, logins *qmgo.Collection, state string) (OIDCLogin, error) {
+	var login OIDCLogin
+	err := logins.Find(ctx, bson.M{
+		"state_hash": HashToken(state),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Apply(qmgo.Change{Remove: true}, &login)
+	if err == qmgo.ErrNoSuchDocuments {
+		return OIDCLogin{}, ErrInvalidOIDCState
+	}
+	return login, err
+}
+
+// GroupRoleMapping maps identity provider groups to the roles they grant
+type GroupRoleMapping map[string][]string
+
+// ParseGroupRoleMapping reads a mapping formatted as
+// "group=role,group=role", where a group can grant several roles
+func ParseGroupRoleMapping(config string) (GroupRoleMapping, error) {
+	mapping := GroupRoleMapping{}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, role, found := strings.Cut(entry, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !found || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid group mapping %q, expected group=role", entry)
+		}
+		mapping[group] = append(mapping[group], role)
+	}
+	return mapping, nil
+}
+
+// SyncRoles returns the roles of a user after a login with the groups. The
+// identity provider is authoritative for the roles of the mapping: they are
+// granted or removed according to the groups. Other roles, assigned by
+// admins, are kept. Saving the result must not demote the last active admin.
+func (m GroupRoleMapping) SyncRoles(current []string, groups []string) []string {
+	managed := map[string]bool{}
+	for _, roles := range m {
+		for _, role := range roles {
+			managed[role] = true
+		}
+	}
+
+	result := map[string]bool{}
+	for _, role := range current {
+		if !managed[role] {
+			result[role] = true
+		}
+	}
+	for _, group := range groups {
+		for _, role := range m[group] {
+			result[role] = true
+		}
+	}
+
+	roles := make([]string, 0, len(result))
+	for role := range result {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -1,8 +1,13 @@
 package models
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 
+	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -12,27 +17,128 @@ type OrderProduct struct {
 	Price float64 `json:"price" bson:"price"`
 }
 
+// OrderItem is a single line of an order, priced at the time of purchase
+type OrderItem struct {
+	Product  OrderProduct `json:"product" bson:"product"`
+	Quantity int          `json:"quantity" bson:"quantity"`
+	Subtotal float64      `json:"subtotal" bson:"subtotal"`
+}
+
 type TimelineEvent struct {
 	Name      string    `json:"name" bson:"name"`
 	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	Actor     string    `json:"actor,omitempty" bson:"actor,omitempty"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
 }
 
 type Order struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id,omitempty"`
 	CustomerID  string             `json:"customer_id" bson:"customer_id"`
-	Product     OrderProduct       `json:"product" bson:"product"`
-	Quantity    int                `json:"quantity" bson:"quantity"`
+	Items       []OrderItem        `json:"items" bson:"items"`
 	TotalAmount float64            `json:"total_amount" bson:"total_amount"`
-	Status      string             `json:"status" bson:"status"`
-	PaymentID   string             `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
-	Timeline    []TimelineEvent    `json:"timeline" bson:"timeline"`
-}
-
-const (
-	OrderStatusCreated   = "Created"
-	OrderStatusConfirmed = "Confirmed"
-	OrderStatusCancelled = "Cancelled"
-	OrderStatusDelivered = "Delivered"
-)
+	Currency    string             `json:"currency,omitempty" bson:"currency,omitempty"`
+	// PaidAmount is what the payment that confirmed the order captured
+	PaidAmount float64 `json:"paid_amount,omitempty" bson:"paid_amount,omitempty"`
+	// RefundedAmount is the total refunded of the order payment
+	RefundedAmount float64 `json:"refunded_amount,omitempty" bson:"refunded_amount,omitempty"`
+	// RefundStatus is set once part or all of the payment was refunded
+	RefundStatus string          `json:"refund_status,omitempty" bson:"refund_status,omitempty"`
+	Status       string          `json:"status" bson:"status"`
+	PaymentID      string          `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	CreatedAt      time.Time       `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at" bson:"updated_at"`
+	Timeline       []TimelineEvent `json:"timeline" bson:"timeline"`
+
+	// Product and Quantity are only set on orders created before line items
+	// existed. Use LineItems to read the lines of any order.
+	Product  *OrderProduct `json:"product,omitempty" bson:"product,omitempty"`
+	Quantity int           `json:"quantity,omitempty" bson:"quantity,omitempty"`
+}
+
+// DefaultCurrency is the currency of orders, and of those created before
+// orders had one
+const DefaultCurrency = "USD"
+
+// PayableCurrency returns the currency the order must be paid in
+func (o *Order) PayableCurrency() string {
+	if o.Currency == "" {
+		return DefaultCurrency
+	}
+	return o.Currency
+}
+
+// IsPayable tells whether the order is waiting for a payment
+func (o *Order) IsPayable() bool {
+	return o.Status == OrderStatusCreated || o.Status == OrderStatusPaymentPending
+}
+
+// MatchesPayment tells whether a payment covers exactly the order total.
+// Amounts are compared in cents so that float rounding doesn't matter.
+func (o *Order) MatchesPayment(amount float64, currency string) bool {
+	return toCents(amount) == toCents(o.TotalAmount) && strings.EqualFold(currency, o.PayableCurrency())
+}
+
+// IsFullyRefunded tells whether refunds totalling the amount give back the
+// whole order total
+func (o *Order) IsFullyRefunded(refundedTotal float64) bool {
+	return toCents(refundedTotal) >= toCents(o.TotalAmount)
+}
+
+// HasRefunded tells whether refunds totalling the amount were already applied
+// to the order, since backend-payment may notify a refund more than once
+func (o *Order) HasRefunded(refundedTotal float64) bool {
+	return toCents(o.RefundedAmount) >= toCents(refundedTotal)
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// LineItems returns the order lines, converting single-product legacy orders
+func (o *Order) LineItems() []OrderItem {
+	if len(o.Items) > 0 || o.Product == nil {
+		return o.Items
+	}
+	return []OrderItem{{
+		Product:  *o.Product,
+		Quantity: o.Quantity,
+		Subtotal: float64(o.Quantity) * o.Product.Price,
+	}}
+}
+
+// RestoreStock puts the quantity of every line back into the product stock
+// and records a cancel_restore movement for each. Run it in the same
+// transaction as the status change that releases the order.
+func (o *Order) RestoreStock(ctx context.Context, db *qmgo.Database, actor, reason string) error {
+	return o.putBackStock(ctx, db, MovementTypeCancelRestore, actor, reason)
+}
+
+// ReturnStock puts the quantity of every line back into the product stock
+// and records a return movement for each, when a refunded order comes back.
+// Run it in the same transaction as the refund.
+func (o *Order) ReturnStock(ctx context.Context, db *qmgo.Database, actor, reason string) error {
+	return o.putBackStock(ctx, db, MovementTypeReturn, actor, reason)
+}
+
+func (o *Order) putBackStock(ctx context.Context, db *qmgo.Database, movementType, actor, reason string) error {
+	for _, item := range o.LineItems() {
+		productID, err := primitive.ObjectIDFromHex(item.Product.ID)
+		if err != nil {
+			return fmt.Errorf("invalid product ID %q in order: %w", item.Product.ID, err)
+		}
+
+		_, err = MoveStock(ctx, db, InventoryMovement{
+			ProductID: productID,
+			Type:      movementType,
+			Quantity:  item.Quantity,
+			OrderID:   o.ID.Hex(),
+			Actor:     actor,
+			Reason:    reason,
+		})
+		if err != nil {
+			return fmt.Errorf("error restoring stock of product %s: %w", item.Product.ID, err)
+		}
+	}
+	return nil
+}
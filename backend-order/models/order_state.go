@@ -0,0 +1,210 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	OrderStatusCreated        = "Created"
+	OrderStatusPaymentPending = "PaymentPending"
+	OrderStatusConfirmed      = "Confirmed"
+	OrderStatusShipped        = "Shipped"
+	OrderStatusDelivered      = "Delivered"
+	OrderStatusCancelled      = "Cancelled"
+	OrderStatusRefunded       = "Refunded"
+	OrderStatusFailed         = "Failed"
+)
+
+// Refund states of an order. They are kept apart from the status, which
+// follows fulfilment, so that a partially refunded order is still shipped.
+// A full refund also moves the order to Refunded.
+const (
+	RefundStatusPartiallyRefunded = "PartiallyRefunded"
+	RefundStatusRefunded          = "Refunded"
+)
+
+// Actors recorded on timeline events that are not caused by a user
+const (
+	ActorSystem         = "system"
+	ActorPaymentService = "backend-payment"
+)
+
+var (
+	// ErrInvalidOrderTransition is returned when the target status can't be reached from the current one
+	ErrInvalidOrderTransition = errors.New("invalid order status transition")
+	// ErrOrderStatusConflict is returned when the order status changed before the transition was applied
+	ErrOrderStatusConflict = errors.New("order status changed concurrently")
+)
+
+// orderTransitions lists, for every status, the statuses it may move to
+var orderTransitions = map[string][]string{
+	OrderStatusCreated:        {OrderStatusPaymentPending, OrderStatusConfirmed, OrderStatusCancelled, OrderStatusFailed},
+	OrderStatusPaymentPending: {OrderStatusCreated, OrderStatusConfirmed, OrderStatusCancelled, OrderStatusFailed},
+	OrderStatusConfirmed:      {OrderStatusShipped, OrderStatusRefunded},
+	OrderStatusShipped:        {OrderStatusDelivered, OrderStatusRefunded},
+	OrderStatusDelivered:      {OrderStatusRefunded},
+	OrderStatusCancelled:      {},
+	OrderStatusRefunded:       {},
+	OrderStatusFailed:         {},
+}
+
+// CanTransitionOrder reports whether an order may move from one status to another
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// UserActor returns the timeline actor for a change made by the user
+func UserActor(user User) string {
+	return "user:" + user.ID.Hex()
+}
+
+// OrderTransition describes a status change and how it is recorded in the timeline
+type OrderTransition struct {
+	To     string
+	Actor  string
+	Reason string
+	// Event is the timeline event name, the target status when empty
+	Event string
+	// Set holds extra fields to update together with the status
+	Set bson.M
+}
+
+func (t OrderTransition) update(now time.Time) bson.M {
+	set := bson.M{}
+	for k, v := range t.Set {
+		set[k] = v
+	}
+	set["status"] = t.To
+	set["updated_at"] = now
+
+	event := t.Event
+	if event == "" {
+		event = t.To
+	}
+
+	return bson.M{
+		"$set": set,
+		"$push": bson.M{
+			"timeline": TimelineEvent{
+				Name:      event,
+				Timestamp: now,
+				Actor:     t.Actor,
+				Reason:    t.Reason,
+			},
+		},
+	}
+}
+
+// TransitionOrder moves the order from its current status to t.To. The update
+// only applies if the stored status still equals order.Status, so concurrent
+// writers can't both act on the same state. On success order is replaced by
+// the updated document.
+func TransitionOrder(ctx context.Context, orders *qmgo.Collection, order *Order, t OrderTransition) error {
+	if !CanTransitionOrder(order.Status, t.To) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidOrderTransition, order.Status, t.To)
+	}
+
+	filter := bson.M{"_id": order.ID, "status": order.Status}
+	change := qmgo.Change{Update: t.update(time.Now()), ReturnNew: true}
+
+	var updated Order
+	if err := orders.Find(ctx, filter).Apply(change, &updated); err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrOrderStatusConflict
+		}
+		return err
+	}
+
+	*order = updated
+	return nil
+}
+
+// TransitionOrders moves every order matching filter and currently in status
+// from to t.To, returning the number of orders changed
+func TransitionOrders(ctx context.Context, orders *qmgo.Collection, filter bson.M, from string, t OrderTransition) (int64, error) {
+	if !CanTransitionOrder(from, t.To) {
+		return 0, fmt.Errorf("%w: %s to %s", ErrInvalidOrderTransition, from, t.To)
+	}
+
+	conditional := bson.M{}
+	for k, v := range filter {
+		conditional[k] = v
+	}
+	conditional["status"] = from
+
+	result, err := orders.UpdateAll(ctx, conditional, t.update(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// RecordPartialRefund records refunds totalling refundedTotal on the order and
+// appends the event to its timeline. The status is left alone, so that the
+// order is still fulfilled, but must be one a refund is allowed from and
+// still equal order.Status, like with TransitionOrder.
+func RecordPartialRefund(ctx context.Context, orders *qmgo.Collection, order *Order, refundedTotal float64, event TimelineEvent) error {
+	if !CanTransitionOrder(order.Status, OrderStatusRefunded) {
+		return fmt.Errorf("%w: %s orders can't be refunded", ErrInvalidOrderTransition, order.Status)
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	change := qmgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"refund_status":   RefundStatusPartiallyRefunded,
+				"refunded_amount": refundedTotal,
+				"updated_at":      event.Timestamp,
+			},
+			"$push": bson.M{"timeline": event},
+		},
+		ReturnNew: true,
+	}
+
+	var updated Order
+	if err := orders.Find(ctx, bson.M{"_id": order.ID, "status": order.Status}).Apply(change, &updated); err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrOrderStatusConflict
+		}
+		return err
+	}
+
+	*order = updated
+	return nil
+}
+
+// RecordOrderEvent appends an event to the timeline without changing the status
+func RecordOrderEvent(ctx context.Context, orders *qmgo.Collection, order *Order, event TimelineEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	change := qmgo.Change{
+		Update: bson.M{
+			"$set":  bson.M{"updated_at": event.Timestamp},
+			"$push": bson.M{"timeline": event},
+		},
+		ReturnNew: true,
+	}
+
+	var updated Order
+	if err := orders.Find(ctx, bson.M{"_id": order.ID}).Apply(change, &updated); err != nil {
+		return err
+	}
+
+	*order = updated
+	return nil
+}
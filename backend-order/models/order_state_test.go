@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCanTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{OrderStatusCreated, OrderStatusPaymentPending, true},
+		{OrderStatusCreated, OrderStatusConfirmed, true},
+		{OrderStatusCreated, OrderStatusCancelled, true},
+		{OrderStatusPaymentPending, OrderStatusCreated, true},
+		{OrderStatusPaymentPending, OrderStatusConfirmed, true},
+		{OrderStatusConfirmed, OrderStatusShipped, true},
+		{OrderStatusShipped, OrderStatusDelivered, true},
+		{OrderStatusConfirmed, OrderStatusRefunded, true},
+		{OrderStatusShipped, OrderStatusRefunded, true},
+		{OrderStatusDelivered, OrderStatusRefunded, true},
+
+		{OrderStatusCreated, OrderStatusShipped, false},
+		{OrderStatusCreated, OrderStatusRefunded, false},
+		{OrderStatusConfirmed, OrderStatusCancelled, false},
+		{OrderStatusConfirmed, OrderStatusCreated, false},
+		{OrderStatusShipped, OrderStatusConfirmed, false},
+		{OrderStatusDelivered, OrderStatusShipped, false},
+		{OrderStatusDelivered, OrderStatusConfirmed, false},
+		{OrderStatusCancelled, OrderStatusCreated, false},
+		{OrderStatusCancelled, OrderStatusConfirmed, false},
+		{OrderStatusRefunded, OrderStatusShipped, false},
+		{OrderStatusFailed, OrderStatusConfirmed, false},
+		{OrderStatusCreated, OrderStatusCreated, false},
+		{"Unknown", OrderStatusConfirmed, false},
+		{OrderStatusCreated, "Unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			if got := CanTransitionOrder(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransitionOrder(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitionOrderRejectsIllegalTransitions(t *testing.T) {
+	tests := []struct {
+		from, to string
+	}{
+		{OrderStatusCreated, OrderStatusDelivered},
+		{OrderStatusConfirmed, OrderStatusCancelled},
+		{OrderStatusCancelled, OrderStatusConfirmed},
+		{OrderStatusRefunded, OrderStatusShipped},
+		{OrderStatusFailed, OrderStatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			order := Order{Status: tt.from}
+			// Illegal transitions are refused before the database is used
+			err := TransitionOrder(context.Background(), nil, &order, OrderTransition{To: tt.to, Actor: ActorSystem})
+			if !errors.Is(err, ErrInvalidOrderTransition) {
+				t.Fatalf("TransitionOrder() error = %v, want ErrInvalidOrderTransition", err)
+			}
+			if order.Status != tt.from {
+				t.Errorf("order status = %s, want it unchanged %s", order.Status, tt.from)
+			}
+		})
+	}
+}
+
+func TestRecordPartialRefundRejectsUnrefundableOrders(t *testing.T) {
+	for _, status := range []string{OrderStatusCreated, OrderStatusPaymentPending, OrderStatusCancelled, OrderStatusRefunded, OrderStatusFailed} {
+		t.Run(status, func(t *testing.T) {
+			order := Order{Status: status}
+			// Refused before the database is used
+			err := RecordPartialRefund(context.Background(), nil, &order, 1, TimelineEvent{Name: "Partially Refunded"})
+			if !errors.Is(err, ErrInvalidOrderTransition) {
+				t.Fatalf("RecordPartialRefund() error = %v, want ErrInvalidOrderTransition", err)
+			}
+		})
+	}
+}
@@ -1,13 +1,42 @@
 package models
 
 import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	ProductStatusDraft        = "draft"
+	ProductStatusActive       = "active"
+	ProductStatusDiscontinued = "discontinued"
+)
+
 // Product represents a product in the store
 type Product struct {
-	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name   string             `json:"name" bson:"name"`
-	Price  float64            `json:"price" bson:"price"`
-	Stocks int                `json:"stocks" bson:"stocks"`
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Price       float64            `json:"price" bson:"price"`
+	Stocks      int                `json:"stocks" bson:"stocks"`
+	Status      string             `json:"status" bson:"status"`
+	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// DeletedAt is set when the product is archived. Archived products stay
+	// resolvable by ID because orders keep pointing at them.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+}
+
+// IsPurchasable reports whether the product is listed in the catalogue and can be ordered.
+// Products stored before statuses existed have no status and count as active.
+func (p Product) IsPurchasable() bool {
+	return p.DeletedAt == nil && (p.Status == "" || p.Status == ProductStatusActive)
+}
+
+// PurchasableProductFilter matches the products for which IsPurchasable is true
+func PurchasableProductFilter() bson.M {
+	return bson.M{
+		"deleted_at": nil,
+		"status":     bson.M{"$in": bson.A{ProductStatusActive, nil}},
+	}
 }
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	opts "github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PermissionOrdersRead     = "orders:read"
+	PermissionOrdersWrite    = "orders:write"
+	PermissionOrdersRefund   = "orders:refund"
+	PermissionProductsRead   = "products:read"
+	PermissionProductsWrite  = "products:write"
+	PermissionInventoryRead  = "inventory:read"
+	PermissionInventoryWrite = "inventory:write"
+	PermissionUsersRead      = "users:read"
+	PermissionUsersManage    = "users:manage"
+	PermissionRolesManage    = "roles:manage"
+)
+
+// AllPermissions lists every permission known to the service
+var AllPermissions = []string{
+	PermissionOrdersRead,
+	PermissionOrdersWrite,
+	PermissionOrdersRefund,
+	PermissionProductsRead,
+	PermissionProductsWrite,
+	PermissionInventoryRead,
+	PermissionInventoryWrite,
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionRolesManage,
+}
+
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleCatalog = "catalog"
+)
+
+// Role is a named set of permissions that can be assigned to users
+type Role struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Permissions []string           `json:"permissions" bson:"permissions"`
+	// BuiltIn roles are created at startup and can't be deleted
+	BuiltIn   bool      `json:"built_in" bson:"built_in"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// BuiltInRoles are stored at startup if missing
+var BuiltInRoles = []Role{
+	{
+		Name:        RoleAdmin,
+		Description: "Full access to the admin API",
+		Permissions: AllPermissions,
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleSupport,
+		Description: "Read access to orders, products and users for customer support",
+		Permissions: []string{PermissionOrdersRead, PermissionProductsRead, PermissionInventoryRead, PermissionUsersRead},
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleCatalog,
+		Description: "Manages products, prices and stock",
+		Permissions: []string{PermissionProductsRead, PermissionProductsWrite, PermissionInventoryRead, PermissionInventoryWrite},
+		BuiltIn:     true,
+	},
+}
+
+// IsKnownPermission reports whether permission is one of AllPermissions
+func IsKnownPermission(permission string) bool {
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureBuiltInRoles stores the built-in roles that don't exist yet. Existing
+// roles are left as they are so that changes made by admins are kept.
+func EnsureBuiltInRoles(ctx context.Context, roles *qmgo.Collection) error {
+	for _, role := range BuiltInRoles {
+		role.UpdatedAt = time.Now()
+		err := roles.UpdateOne(ctx, bson.M{"name": role.Name}, bson.M{"$setOnInsert": role},
+			opts.UpdateOptions{UpdateOptions: options.Update().SetUpsert(true)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolvePermissions returns the set of permissions granted to the user by its roles
+func ResolvePermissions(ctx context.Context, roles *qmgo.Collection, user User) (map[string]bool, error) {
+	granted := map[string]bool{}
+
+	names := user.RoleNames()
+	if len(names) == 0 {
+		return granted, nil
+	}
+
+	var assigned []Role
+	if err := roles.Find(ctx, bson.M{"name": bson.M{"$in": names}}).All(&assigned); err != nil {
+		return nil, err
+	}
+	for _, role := range assigned {
+		for _, permission := range role.Permissions {
+			granted[permission] = true
+		}
+	}
+	return granted, nil
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventIPLocked        = "ip_locked"
+	SecurityEventMFAEnabled      = "mfa_enabled"
+	SecurityEventMFADisabled     = "mfa_disabled"
+	SecurityEventMFAReset        = "mfa_reset"
+	SecurityEventRecoveryCodeUse = "mfa_recovery_code_used"
+	SecurityEventAPIKeyCreated   = "api_key_created"
+	SecurityEventAPIKeyRevoked   = "api_key_revoked"
+	SecurityEventSSOLinked       = "sso_linked"
+	SecurityEventAccountDisabled = "account_disabled"
+	SecurityEventAccountEnabled  = "account_enabled"
+	SecurityEventRolesChanged    = "roles_changed"
+	SecurityEventUserDeleted     = "user_deleted"
+)
+
+// SecurityEvent is an entry of the audit trail of authentication events
+type SecurityEvent struct {
+	ID     primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Type   string              `json:"type" bson:"type"`
+	UserID *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Email  string              `json:"email,omitempty" bson:"email,omitempty"`
+	IP     string              `json:"ip,omitempty" bson:"ip,omitempty"`
+	// Actor is ActorSystem for automatic events, or the admin behind the event
+	Actor     string    `json:"actor" bson:"actor"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// RecordSecurityEvent stores the event in the security_events collection
+func RecordSecurityEvent(ctx context.Context, db *qmgo.Database, event SecurityEvent) error {
+	if event.Actor == "" {
+		event.Actor = ActorSystem
+	}
+	event.CreatedAt = time.Now()
+	_, err := db.Collection("security_events").InsertOne(ctx, event)
+	return err
+}
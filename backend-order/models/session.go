@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means a rotated token was presented again, which
+	// happens when it was stolen. The whole session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// RefreshToken is the server side record of a refresh token. Only the hash
+// of the token is stored. Every refresh replaces the token with a new one of
+// the same session, so a session is the chain of tokens issued from a login.
+type RefreshToken struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	SessionID primitive.ObjectID `json:"session_id" bson:"session_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	UserAgent string             `json:"user_agent" bson:"user_agent"`
+	// MFA tells whether the session was opened with a second factor
+	MFA       bool      `json:"mfa" bson:"mfa"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	// RotatedAt is set once the token was exchanged for a new one
+	RotatedAt *time.Time `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// HashToken returns the hash secret tokens, such as refresh and password
+// reset tokens, are stored and looked up by
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken stores a new refresh token for the session and returns it
+// in clear. Pass primitive.NilObjectID to start a new session.
+func IssueRefreshToken(ctx context.Context, tokens *qmgo.Collection, userID, sessionID primitive.ObjectID, mfa bool, userAgent string, ttl time.Duration) (string, RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", RefreshToken{}, err
+	}
+	token := hex.EncodeToString(b)
+
+	if sessionID.IsZero() {
+		sessionID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	record := RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenHash: HashToken(token),
+		UserAgent: userAgent,
+		MFA:       mfa,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := tokens.InsertOne(ctx, record); err != nil {
+		return "", RefreshToken{}, err
+	}
+	return token, record, nil
+}
+
+// ConsumeRefreshToken marks a valid refresh token as rotated and returns its
+// record. A token that was already rotated revokes its whole session.
+func ConsumeRefreshToken(ctx context.Context, tokens *qmgo.Collection, token string) (RefreshToken, error) {
+	now := time.Now()
+	hash := HashToken(token)
+
+	var record RefreshToken
+	err := tokens.Find(ctx, bson.M{
+		"token_hash": hash,
+		"rotated_at": nil,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": now},
+	}).Apply(qmgo.Change{Update: bson.M{"$set": bson.M{"rotated_at": now}}}, &record)
+	if err == nil {
+		return record, nil
+	}
+	if err != qmgo.ErrNoSuchDocuments {
+		return RefreshToken{}, err
+	}
+
+	// Tell a replayed token from an unknown, expired or revoked one
+	if err := tokens.Find(ctx, bson.M{"token_hash": hash}).One(&record); err != nil {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+	if record.RotatedAt != nil && record.RevokedAt == nil {
+		if err := RevokeSession(ctx, tokens, record.SessionID); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	return RefreshToken{}, ErrInvalidRefreshToken
+}
+
+// RevokeSession revokes every refresh token of a session
+func RevokeSession(ctx context.Context, tokens *qmgo.Collection, sessionID primitive.ObjectID) error {
+	_, err := tokens.UpdateAll(ctx,
+		bson.M{"session_id": sessionID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// RevokeUserSessions revokes every refresh token of the user and bumps its
+// token version, which invalidates the access tokens already issued
+func RevokeUserSessions(ctx context.Context, db *qmgo.Database, userID primitive.ObjectID) error {
+	err := db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$inc": bson.M{"token_version": 1}})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("refresh_tokens").UpdateAll(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// RevokeUserAccess revokes every session and API key of the user, for
+// changes after which no credential issued before may keep working: logging
+// out everywhere, a new password, a reset second factor or a disabled account
+func RevokeUserAccess(ctx context.Context, db *qmgo.Database, userID primitive.ObjectID) error {
+	if err := RevokeUserSessions(ctx, db, userID); err != nil {
+		return err
+	}
+	return RevokeUserAPIKeys(ctx, db.Collection("api_keys"), userID)
+}
+
+// IsSessionRevoked reports whether the session was logged out or revoked
+func IsSessionRevoked(ctx context.Context, tokens *qmgo.Collection, sessionID primitive.ObjectID) (bool, error) {
+	count, err := tokens.Find(ctx, bson.M{"session_id": sessionID, "revoked_at": bson.M{"$ne": nil}}).Count()
+	return count > 0, err
+}
@@ -12,8 +12,49 @@ type User struct {
 	Email         string             `json:"email" bson:"email"`
 	Password      string             `json:"-" bson:"password"` // The "-" tag means this field won't be included in JSON output
 	IsAdmin       bool               `json:"isAdmin" bson:"isAdmin"`
-	ResetToken    string             `json:"-" bson:"resetToken,omitempty"`
-	ResetTokenExp time.Time          `json:"-" bson:"resetTokenExp,omitempty"`
+	Roles         []string           `json:"roles" bson:"roles,omitempty"`
+	EmailVerified bool               `json:"email_verified" bson:"email_verified"`
+	// Disabled users can't log in and their tokens and API keys are refused
+	Disabled       bool       `json:"disabled" bson:"disabled,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty" bson:"disabled_reason,omitempty"`
+	// ResetTokenHash is the hash of the last password reset token sent
+	ResetTokenHash string    `json:"-" bson:"resetTokenHash,omitempty"`
+	ResetTokenExp  time.Time `json:"-" bson:"resetTokenExp,omitempty"`
+	// MFAEnabled is set once the user confirmed a TOTP secret with a code
+	MFAEnabled bool   `json:"mfa_enabled" bson:"mfa_enabled"`
+	MFASecret  string `json:"-" bson:"mfa_secret,omitempty"`
+	// MFAPendingSecret is the secret being enrolled, until confirmed
+	MFAPendingSecret string `json:"-" bson:"mfa_pending_secret,omitempty"`
+	// MFALastStep is the TOTP time step of the last accepted code, which can't be used again
+	MFALastStep       int64    `json:"-" bson:"mfa_last_step,omitempty"`
+	MFARecoveryHashes []string `json:"-" bson:"mfa_recovery_codes,omitempty"`
+	// OIDCIssuer and OIDCSubject identify the identity provider account the
+	// user logs in with through single sign-on
+	OIDCIssuer  string `json:"oidc_issuer,omitempty" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
+	// TokenVersion is embedded in access tokens, bumping it revokes them all
+	TokenVersion int `json:"-" bson:"token_version"`
+}
+
+// RoleNames returns the roles of the user. Users flagged with IsAdmin before
+// roles existed are treated as holding the admin role.
+func (u User) RoleNames() []string {
+	names := append([]string{}, u.Roles...)
+	if u.IsAdmin && !u.HasRole(RoleAdmin) {
+		names = append(names, RoleAdmin)
+	}
+	return names
+}
+
+// HasRole reports whether the role is explicitly assigned to the user
+func (u User) HasRole(name string) bool {
+	for _, role := range u.Roles {
+		if role == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (u *User) SetPassword(password string) error {
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeletedCustomerID replaces the customer of the orders of deleted users
+const DeletedCustomerID = "deleted-user"
+
+// lastAdminLock is the lock document of changes that may remove the last admin
+const lastAdminLock = "last-admin"
+
+// ErrLastAdmin is returned when a change would leave no enabled admin
+var ErrLastAdmin = errors.New("the last active admin can't be removed, disabled or demoted")
+
+// IsActiveAdmin tells whether the user holds the admin role and is enabled
+func (u User) IsActiveAdmin() bool {
+	if u.Disabled {
+		return false
+	}
+	for _, role := range u.RoleNames() {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureAnotherActiveAdmin returns ErrLastAdmin when no enabled admin other
+// than the user exists. Call it in the transaction that demotes, disables or
+// deletes an active admin: it writes a lock shared by those transactions, so
+// that two of them can't each count the other admin and both commit.
+func EnsureAnotherActiveAdmin(ctx context.Context, db *qmgo.Database, userID primitive.ObjectID) error {
+	// Concurrent transactions conflict on the lock and all but one are retried
+	err := db.Collection("locks").Find(ctx, bson.M{"_id": lastAdminLock}).Apply(qmgo.Change{
+		Update: bson.M{"$set": bson.M{"updated_at": time.Now()}},
+		Upsert: true,
+	}, &bson.M{})
+	if err != nil {
+		return err
+	}
+
+	count, err := db.Collection("users").Find(ctx, bson.M{
+		"_id":      bson.M{"$ne": userID},
+		"disabled": bson.M{"$ne": true},
+		"$or":      []bson.M{{"roles": RoleAdmin}, {"isAdmin": true}},
+	}).Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// DeleteUser removes the user with their credentials, sessions and cart. Their
+// orders are kept for accounting but no longer point to them, and their
+// security events lose their email. Run it in a transaction.
+func DeleteUser(ctx context.Context, db *qmgo.Database, user User) error {
+	anonymize := bson.M{
+		"$set":   bson.M{"customer_id": DeletedCustomerID},
+		"$unset": bson.M{"user_id": ""},
+	}
+	// Orders created before user IDs only name the customer by email
+	_, err := db.Collection("orders").UpdateAll(ctx, bson.M{"$or": []bson.M{
+		{"user_id": user.ID},
+		{"customer_id": user.Email},
+	}}, anonymize)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("security_events").UpdateAll(ctx,
+		bson.M{"user_id": user.ID},
+		bson.M{"$unset": bson.M{"email": ""}})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"carts", "refresh_tokens", "api_keys"} {
+		if _, err := db.Collection(name).RemoveAll(ctx, bson.M{"user_id": user.ID}); err != nil {
+			return err
+		}
+	}
+	throttleKeys := []string{AccountThrottleKey(user.Email), VerificationThrottleKey(user.Email)}
+	if _, err := db.Collection("login_throttles").RemoveAll(ctx, bson.M{"key": bson.M{"$in": throttleKeys}}); err != nil {
+		return err
+	}
+
+	return db.Collection("users").Remove(ctx, bson.M{"_id": user.ID})
+}
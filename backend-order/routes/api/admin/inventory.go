@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-order/database"
+	"backend-order/middleware"
+	"backend-order/models"
+)
+
+func SetupAdminInventoryRoutes(r *gin.Engine) {
+	adminGroup := NewRouter(r)
+	{
+		adminGroup.GET("/products/:id/stock-movements", models.PermissionInventoryRead, ListStockMovements)
+		adminGroup.POST("/products/:id/stock-movements", models.PermissionInventoryWrite, CreateStockMovement)
+		adminGroup.GET("/inventory/reconciliation", models.PermissionInventoryRead, ReconcileInventory)
+	}
+}
+
+// StockMovementInput defines the structure for a manual stock change
+type StockMovementInput struct {
+	Type string `json:"type" binding:"required,oneof=adjustment restock return"`
+	// Quantity is added to the stock, use a negative value to remove stock
+	Quantity int    `json:"quantity" binding:"required,ne=0"`
+	OrderID  string `json:"order_id"`
+	Reason   string `json:"reason" binding:"required"`
+}
+
+// ReconciliationEntry compares the stock of a product with the sum of its ledger
+type ReconciliationEntry struct {
+	ProductID   string `json:"product_id"`
+	Name        string `json:"name"`
+	Stocks      int    `json:"stocks"`
+	LedgerTotal int    `json:"ledger_total"`
+	Difference  int    `json:"difference"`
+}
+
+// ListStockMovements godoc
+// @Summary List stock movements
+// @Description List the inventory ledger of a product, newest first (admin only)
+// @Tags admin,inventory
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {array} models.InventoryMovement
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/products/{id}/stock-movements [get]
+func ListStockMovements(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var movements []models.InventoryMovement
+	err = database.GetDB().Collection("inventory_movements").
+		Find(context.Background(), bson.M{"product_id": productID}).
+		Sort("-created_at").
+		All(&movements)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock movements"})
+		return
+	}
+
+	if movements == nil {
+		movements = []models.InventoryMovement{}
+	}
+
+	c.JSON(http.StatusOK, movements)
+}
+
+// CreateStockMovement godoc
+// @Summary Adjust stock
+// @Description Record an adjustment, restock or return and apply it to the product stock (admin only)
+// @Tags admin,inventory
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param movement body StockMovementInput true "Stock movement"
+// @Success 201 {object} models.InventoryMovement
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/products/{id}/stock-movements [post]
+func CreateStockMovement(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var input StockMovementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var movement models.InventoryMovement
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		var err error
+		movement, err = models.MoveStock(sessCtx, database.GetDB(), models.InventoryMovement{
+			ProductID: productID,
+			Type:      input.Type,
+			Quantity:  input.Quantity,
+			OrderID:   input.OrderID,
+			Actor:     models.UserActor(user),
+			Reason:    input.Reason,
+		})
+		return movement, err
+	}
+
+	if _, err := database.GetClient().DoTransaction(c, callback); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientStock):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Stock can't go below zero"})
+		case err == qmgo.ErrNoSuchDocuments:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust stock"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, movement)
+}
+
+// ReconcileInventory godoc
+// @Summary Reconcile inventory
+// @Description Compare the stock of every product with the sum of its ledger. Only mismatches are returned unless all=true (admin only)
+// @Tags admin,inventory
+// @Produce json
+// @Param all query bool false "Include products whose ledger matches"
+// @Success 200 {array} ReconciliationEntry
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/inventory/reconciliation [get]
+func ReconcileInventory(c *gin.Context) {
+	ctx := context.Background()
+	db := database.GetDB()
+
+	var totals []struct {
+		ProductID primitive.ObjectID `bson:"_id"`
+		Total     int                `bson:"total"`
+	}
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$product_id", "total": bson.M{"$sum": "$quantity"}}},
+	}
+	if err := db.Collection("inventory_movements").Aggregate(ctx, pipeline).All(&totals); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sum inventory ledger"})
+		return
+	}
+	ledger := make(map[primitive.ObjectID]int, len(totals))
+	for _, t := range totals {
+		ledger[t.ProductID] = t.Total
+	}
+
+	var products []models.Product
+	if err := db.Collection("products").Find(ctx, bson.M{}).Sort("name").All(&products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+
+	includeAll := c.Query("all") == "true"
+	entries := []ReconciliationEntry{}
+	for _, product := range products {
+		entry := ReconciliationEntry{
+			ProductID:   product.ID.Hex(),
+			Name:        product.Name,
+			Stocks:      product.Stocks,
+			LedgerTotal: ledger[product.ID],
+			Difference:  product.Stocks - ledger[product.ID],
+		}
+		if entry.Difference != 0 || includeAll {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
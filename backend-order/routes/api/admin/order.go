@@ -3,52 +3,234 @@ package admin
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"backend-order/database"
-	"backend-order/middleware"
+	"backend-order/helpers"
 	"backend-order/models"
 )
 
-// SetupAdminRoutes sets up the admin-related routes
+// SetupAdminOrderRoutes sets up the admin order routes
 func SetupAdminOrderRoutes(r *gin.Engine) {
-	adminGroup := r.Group("/admin")
-	adminGroup.Use(middleware.AuthMiddleware()) // Ensure this middleware checks for admin role
+	adminGroup := NewRouter(r)
 	{
-		adminGroup.GET("/orders", GetAllOrders)
-		// Add other admin routes here
+		adminGroup.GET("/orders", models.PermissionOrdersRead, GetAllOrders)
+		adminGroup.GET("/orders/summary", models.PermissionOrdersRead, GetOrderSummary)
 	}
 }
 
+// orderSortFields maps the sort keys accepted by /admin/orders to document fields
+var orderSortFields = map[string]string{
+	"created_at":   "created_at",
+	"updated_at":   "updated_at",
+	"total_amount": "total_amount",
+}
+
+// OrderStatusCount is the number of orders in one status
+type OrderStatusCount struct {
+	Status string `json:"status" bson:"_id"`
+	Count  int64  `json:"count" bson:"count"`
+}
+
 // GetAllOrders godoc
 // @Summary Get all orders
-// @Description Retrieve all orders from all users (admin only)
+// @Description Search the orders of all users with filters and cursor pagination (admin only)
 // @Tags admin,orders
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.Order
+// @Param status query string false "Comma separated statuses"
+// @Param customer query string false "Customer user ID or email"
+// @Param product query string false "Product ID contained in the order"
+// @Param from query string false "Created at or after, RFC 3339 or YYYY-MM-DD"
+// @Param to query string false "Created before, RFC 3339 or YYYY-MM-DD"
+// @Param min_total query number false "Minimum total amount"
+// @Param max_total query number false "Maximum total amount"
+// @Param sort query string false "Comma separated sort keys among created_at, updated_at, total_amount; prefix with - for descending" default(-created_at)
+// @Param limit query int false "Page size, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} helpers.Page[models.Order]
+// @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /admin/orders [get]
 func GetAllOrders(c *gin.Context) {
+	filter, ok := orderFilterFromQuery(c, true)
+	if !ok {
+		return
+	}
+
+	keys, err := helpers.ParseSort(c.Query("sort"), orderSortFields, "-created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := helpers.ParseLimit(c.Query("limit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx := context.Background()
-	db := database.GetDB()
+	collection := database.GetDB().Collection("orders")
 
-	// Set up options for sorting by creation date, newest first
+	total, err := collection.Find(ctx, filter).Count()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	pageFilter := filter
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := helpers.CursorFilter(keys, cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pageFilter = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	// Fetch one extra order to know whether there is a next page
 	var orders []models.Order
-	err := db.Collection("orders").Find(ctx, qmgo.M{}).Sort("-created_at").All(&orders)
+	err = collection.Find(ctx, pageFilter).Sort(helpers.SortFields(keys)...).Limit(limit + 1).All(&orders)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
 		return
 	}
 
+	page := helpers.Page[models.Order]{Items: orders, Total: total}
+	if int64(len(orders)) > limit {
+		page.Items = orders[:limit]
+		page.NextCursor, err = helpers.EncodeCursor(keys, page.Items[limit-1])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to paginate orders"})
+			return
+		}
+	}
+
 	// If orders is nil, initialize it as an empty slice
-	if orders == nil {
-		orders = []models.Order{}
+	if page.Items == nil {
+		page.Items = []models.Order{}
+	}
+
+	// Present orders placed before line items existed in the same shape
+	for i := range page.Items {
+		page.Items[i].Items = page.Items[i].LineItems()
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetOrderSummary godoc
+// @Summary Count orders per status
+// @Description Count the orders in every status, with the same filters as the order listing except status (admin only)
+// @Tags admin,orders
+// @Produce json
+// @Param customer query string false "Customer user ID or email"
+// @Param product query string false "Product ID contained in the order"
+// @Param from query string false "Created at or after, RFC 3339 or YYYY-MM-DD"
+// @Param to query string false "Created before, RFC 3339 or YYYY-MM-DD"
+// @Param min_total query number false "Minimum total amount"
+// @Param max_total query number false "Maximum total amount"
+// @Success 200 {array} OrderStatusCount
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/orders/summary [get]
+func GetOrderSummary(c *gin.Context) {
+	filter, ok := orderFilterFromQuery(c, false)
+	if !ok {
+		return
+	}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	counts := []OrderStatusCount{}
+	err := database.GetDB().Collection("orders").Aggregate(context.Background(), pipeline).All(&counts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// orderFilterFromQuery builds the order filter from the query string. It
+// writes a 400 response and returns false when a parameter is invalid.
+func orderFilterFromQuery(c *gin.Context, withStatus bool) (bson.M, bool) {
+	conditions := []bson.M{}
+
+	if status := c.Query("status"); status != "" && withStatus {
+		conditions = append(conditions, bson.M{"status": bson.M{"$in": strings.Split(status, ",")}})
+	}
+
+	if customer := c.Query("customer"); customer != "" {
+		if userID, err := primitive.ObjectIDFromHex(customer); err == nil {
+			conditions = append(conditions, bson.M{"user_id": userID})
+		} else {
+			conditions = append(conditions, bson.M{"customer_id": customer})
+		}
+	}
+
+	if product := c.Query("product"); product != "" {
+		// Orders placed before line items existed keep the product at the top level
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"items.product.id": product},
+			{"product.id": product},
+		}})
+	}
+
+	created := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+		if value := c.Query(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return nil, false
+			}
+			created[op] = t
+		}
+	}
+	if len(created) > 0 {
+		conditions = append(conditions, bson.M{"created_at": created})
 	}
 
-	c.JSON(http.StatusOK, orders)
+	amount := bson.M{}
+	for param, op := range map[string]string{"min_total": "$gte", "max_total": "$lte"} {
+		if value := c.Query(param); value != "" {
+			total, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return nil, false
+			}
+			amount[op] = total
+		}
+	}
+	if len(amount) > 0 {
+		conditions = append(conditions, bson.M{"total_amount": amount})
+	}
+
+	if len(conditions) == 0 {
+		return bson.M{}, true
+	}
+	return bson.M{"$and": conditions}, true
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
 }
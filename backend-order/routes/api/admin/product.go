@@ -3,8 +3,10 @@ package admin
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
@@ -14,24 +16,101 @@ import (
 )
 
 func SetupAdminProductRoutes(r *gin.Engine) {
-	adminGroup := r.Group("/admin")
-	adminGroup.Use(middleware.AuthMiddleware(), middleware.AdminOnly())
+	adminGroup := NewRouter(r)
 	{
-		adminGroup.POST("/products", CreateProduct)
-		adminGroup.DELETE("/products/:id", DeleteProduct)
+		adminGroup.GET("/products", models.PermissionProductsRead, ListProducts)
+		adminGroup.GET("/products/:id", models.PermissionProductsRead, GetProduct)
+		adminGroup.POST("/products", models.PermissionProductsWrite, CreateProduct)
+		adminGroup.PUT("/products/:id", models.PermissionProductsWrite, UpdateProduct)
+		adminGroup.PATCH("/products/:id", models.PermissionProductsWrite, PatchProduct)
+		adminGroup.DELETE("/products/:id", models.PermissionProductsWrite, DeleteProduct)
+		adminGroup.POST("/products/:id/restore", models.PermissionProductsWrite, RestoreProduct)
 	}
 }
 
-// ProductInput defines the structure for product creation input
+// ProductInput defines the structure for product creation and full update input
 type ProductInput struct {
 	Name        string  `json:"name" binding:"required"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price" binding:"required,gt=0"`
-	// Add other fields as needed
+	// Stocks defaults to 0 for a new product, and to the current stock for
+	// an update
+	Stocks *int `json:"stocks" binding:"omitempty,min=0"`
+	// Status defaults to active when empty
+	Status string `json:"status" binding:"omitempty,oneof=draft active discontinued"`
+}
+
+// ProductPatchInput defines the structure for partial product updates, only set fields are changed
+type ProductPatchInput struct {
+	Name        *string  `json:"name" binding:"omitempty,min=1"`
+	Description *string  `json:"description"`
+	Price       *float64 `json:"price" binding:"omitempty,gt=0"`
+	Stocks      *int     `json:"stocks" binding:"omitempty,min=0"`
+	Status      *string  `json:"status" binding:"omitempty,oneof=draft active discontinued"`
+}
+
+// ListProducts godoc
+// @Summary List all products
+// @Description List every product including drafts, discontinued and archived ones (admin only)
+// @Tags admin,products
+// @Produce json
+// @Param include_deleted query bool false "Include archived products"
+// @Success 200 {array} models.Product
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/products [get]
+func ListProducts(c *gin.Context) {
+	filter := bson.M{}
+	if c.Query("include_deleted") != "true" {
+		filter["deleted_at"] = nil
+	}
+
+	var products []models.Product
+	err := database.GetDB().Collection("products").Find(context.Background(), filter).Sort("name").All(&products)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+
+	if products == nil {
+		products = []models.Product{}
+	}
+
+	c.JSON(http.StatusOK, products)
+}
+
+// GetProduct godoc
+// @Summary Get a product
+// @Description Get a product by ID, including archived ones (admin only)
+// @Tags admin,products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} models.Product
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/products/{id} [get]
+func GetProduct(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var product models.Product
+	err = database.GetDB().Collection("products").Find(context.Background(), bson.M{"_id": objID}).One(&product)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
 }
 
 // CreateProduct godoc
-// @Summary Create a new product zzz
+// @Summary Create a new product
 // @Description Create a new product (admin only)
 // @Tags admin,products
 // @Accept json
@@ -43,22 +122,54 @@ type ProductInput struct {
 // @Failure 403 {object} map[string]string
 // @Router /admin/products [post]
 func CreateProduct(c *gin.Context) {
-	var newProduct models.Product
+	var input ProductInput
 
-	// Bind JSON body to the newProduct struct
-	if err := c.ShouldBindJSON(&newProduct); err != nil {
+	// Bind JSON body to the input struct
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product data"})
 		return
 	}
 
-	// Generate a new ObjectID for the product
-	newProduct.ID = primitive.NewObjectID()
+	if input.Status == "" {
+		input.Status = models.ProductStatusActive
+	}
 
-	// Insert the new product into the database
-	ctx := context.Background()
-	db := database.GetDB()
-	_, err := db.Collection("products").InsertOne(ctx, newProduct)
-	if err != nil {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	newProduct := models.Product{
+		ID:          primitive.NewObjectID(),
+		Name:        input.Name,
+		Description: input.Description,
+		Price:       input.Price,
+		Status:      input.Status,
+		UpdatedAt:   time.Now(),
+	}
+
+	// Insert the product without stock, the initial stock goes through the ledger
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		db := database.GetDB()
+		if _, err := db.Collection("products").InsertOne(sessCtx, newProduct); err != nil {
+			return nil, err
+		}
+		if input.Stocks == nil || *input.Stocks == 0 {
+			return nil, nil
+		}
+
+		movement, err := models.MoveStock(sessCtx, db, models.InventoryMovement{
+			ProductID: newProduct.ID,
+			Type:      models.MovementTypeOpeningBalance,
+			Quantity:  *input.Stocks,
+			Actor:     models.UserActor(user),
+			Reason:    "Initial stock",
+		})
+		newProduct.Stocks = movement.StockAfter
+		return nil, err
+	}
+
+	if _, err := database.GetClient().DoTransaction(c, callback); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
@@ -67,9 +178,106 @@ func CreateProduct(c *gin.Context) {
 	c.JSON(http.StatusCreated, newProduct)
 }
 
+// UpdateProduct godoc
+// @Summary Replace a product
+// @Description Update the name, description, price, stock and status of a product. The stock is left unchanged when omitted (admin only)
+// @Tags admin,products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param product body ProductInput true "Product information"
+// @Success 200 {object} models.Product
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/products/{id} [put]
+func UpdateProduct(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var input ProductInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Status == "" {
+		input.Status = models.ProductStatusActive
+	}
+
+	set := bson.M{
+		"name":        input.Name,
+		"description": input.Description,
+		"price":       input.Price,
+		"status":      input.Status,
+	}
+	if input.Stocks != nil {
+		set["stocks"] = *input.Stocks
+	}
+	applyProductUpdate(c, objID, set)
+}
+
+// PatchProduct godoc
+// @Summary Update a product
+// @Description Update only the given fields of a product (admin only)
+// @Tags admin,products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param product body ProductPatchInput true "Fields to update"
+// @Success 200 {object} models.Product
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/products/{id} [patch]
+func PatchProduct(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var input ProductPatchInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	set := bson.M{}
+	if input.Name != nil {
+		set["name"] = *input.Name
+	}
+	if input.Description != nil {
+		set["description"] = *input.Description
+	}
+	if input.Price != nil {
+		set["price"] = *input.Price
+	}
+	if input.Stocks != nil {
+		set["stocks"] = *input.Stocks
+	}
+	if input.Status != nil {
+		set["status"] = *input.Status
+	}
+
+	if len(set) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	applyProductUpdate(c, objID, set)
+}
+
 // DeleteProduct godoc
-// @Summary Delete a product
-// @Description Delete a product by ID (admin only)
+// @Summary Archive a product
+// @Description Archive a product by ID so it is hidden from the catalogue but stays resolvable for existing orders (admin only)
 // @Tags admin,products
 // @Accept json
 // @Produce json
@@ -92,16 +300,107 @@ func DeleteProduct(c *gin.Context) {
 	db := database.GetDB()
 	ctx := context.Background()
 
-	result, err := db.Collection("products").RemoveAll(ctx, bson.M{"_id": objID})
+	now := time.Now()
+	err = db.Collection("products").UpdateOne(ctx, bson.M{"_id": objID, "deleted_at": nil}, bson.M{
+		"$set": bson.M{"deleted_at": now, "updated_at": now},
+	})
 	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+}
+
+// RestoreProduct godoc
+// @Summary Restore an archived product
+// @Description Bring an archived product back into the catalogue (admin only)
+// @Tags admin,products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} models.Product
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/products/{id}/restore [post]
+func RestoreProduct(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+	var product models.Product
+	change := qmgo.Change{
+		Update: bson.M{
+			"$unset": bson.M{"deleted_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+		ReturnNew: true,
+	}
+	err = database.GetDB().Collection("products").Find(context.Background(), bson.M{"_id": objID, "deleted_at": bson.M{"$ne": nil}}).Apply(change, &product)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Archived product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
+}
+
+// applyProductUpdate sets the given fields and responds with the updated
+// product. A stock change is recorded as an adjustment in the inventory ledger.
+func applyProductUpdate(c *gin.Context, productID primitive.ObjectID, set bson.M) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	set["updated_at"] = time.Now()
+	stocks, setsStock := set["stocks"].(int)
+	delete(set, "stocks")
+
+	var product models.Product
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		db := database.GetDB()
+
+		change := qmgo.Change{Update: bson.M{"$set": set}, ReturnNew: true}
+		if err := db.Collection("products").Find(sessCtx, bson.M{"_id": productID}).Apply(change, &product); err != nil {
+			return nil, err
+		}
+
+		if setsStock && stocks != product.Stocks {
+			movement, err := models.MoveStock(sessCtx, db, models.InventoryMovement{
+				ProductID: productID,
+				Type:      models.MovementTypeAdjustment,
+				Quantity:  stocks - product.Stocks,
+				Actor:     models.UserActor(user),
+				Reason:    "Stock set by product update",
+			})
+			if err != nil {
+				return nil, err
+			}
+			product.Stocks = movement.StockAfter
+		}
+		return nil, nil
+	}
+
+	if _, err := database.GetClient().DoTransaction(c, callback); err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
 }
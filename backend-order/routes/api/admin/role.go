@@ -0,0 +1,294 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-order/database"
+	"backend-order/models"
+)
+
+// SetupAdminRoleRoutes sets up the admin role management routes
+func SetupAdminRoleRoutes(r *gin.Engine) {
+	adminGroup := NewRouter(r)
+	{
+		adminGroup.GET("/permissions", models.PermissionRolesManage, ListPermissions)
+		adminGroup.GET("/roles", models.PermissionRolesManage, ListRoles)
+		adminGroup.POST("/roles", models.PermissionRolesManage, CreateRole)
+		adminGroup.PUT("/roles/:name", models.PermissionRolesManage, UpdateRole)
+		adminGroup.DELETE("/roles/:name", models.PermissionRolesManage, DeleteRole)
+	}
+}
+
+var errUnknownRole = errors.New("unknown role")
+
+// RoleInput defines the structure for creating a role
+type RoleInput struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// RoleUpdateInput defines the structure for updating a role
+type RoleUpdateInput struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// ListPermissions godoc
+// @Summary List permissions
+// @Description List every permission that can be granted to a role (admin only)
+// @Tags admin,roles
+// @Produce json
+// @Success 200 {array} string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /admin/permissions [get]
+func ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, models.AllPermissions)
+}
+
+// ListRoles godoc
+// @Summary List roles
+// @Description List the roles and the permissions they grant (admin only)
+// @Tags admin,roles
+// @Produce json
+// @Success 200 {array} models.Role
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/roles [get]
+func ListRoles(c *gin.Context) {
+	var roles []models.Role
+	err := database.GetDB().Collection("roles").Find(context.Background(), bson.M{}).Sort("name").All(&roles)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve roles"})
+		return
+	}
+
+	if roles == nil {
+		roles = []models.Role{}
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// CreateRole godoc
+// @Summary Create a role
+// @Description Create a role granting a set of permissions, which the admin must hold (admin only)
+// @Tags admin,roles
+// @Accept json
+// @Produce json
+// @Param role body RoleInput true "Role"
+// @Success 201 {object} models.Role
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/roles [post]
+func CreateRole(c *gin.Context) {
+	var input RoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePermissions(input.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkGrantedPermissions(c, input.Permissions) {
+		return
+	}
+
+	role := models.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+		UpdatedAt:   time.Now(),
+	}
+
+	result, err := database.GetDB().Collection("roles").InsertOne(context.Background(), role)
+	if err != nil {
+		if qmgo.IsDup(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Role already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	role.ID = result.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole godoc
+// @Summary Update a role
+// @Description Replace the description and permissions of a role. The permissions of the admin role can't be changed, and the admin must hold every permission of the role, before and after the change (admin only)
+// @Tags admin,roles
+// @Accept json
+// @Produce json
+// @Param name path string true "Role name"
+// @Param role body RoleUpdateInput true "Role"
+// @Success 200 {object} models.Role
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/roles/{name} [put]
+func UpdateRole(c *gin.Context) {
+	name := c.Param("name")
+
+	var input RoleUpdateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePermissions(input.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Keep at least one role able to manage roles and users
+	if name == models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The permissions of the admin role can't be changed"})
+		return
+	}
+
+	ctx := context.Background()
+	roles := database.GetDB().Collection("roles")
+
+	var role models.Role
+	if err := roles.Find(ctx, bson.M{"name": name}).One(&role); err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+	// Removing a permission from the users of the role is a change too
+	if !checkGrantedPermissions(c, append(role.Permissions, input.Permissions...)) {
+		return
+	}
+
+	err := roles.Find(ctx, bson.M{"name": name}).Apply(qmgo.Change{
+		Update: bson.M{"$set": bson.M{
+			"description": input.Description,
+			"permissions": input.Permissions,
+			"updated_at":  time.Now(),
+		}},
+		ReturnNew: true,
+	}, &role)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole godoc
+// @Summary Delete a role
+// @Description Delete a role that is neither built in nor assigned to any user (admin only)
+// @Tags admin,roles
+// @Produce json
+// @Param name path string true "Role name"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/roles/{name} [delete]
+func DeleteRole(c *gin.Context) {
+	name := c.Param("name")
+	ctx := context.Background()
+	db := database.GetDB()
+
+	var role models.Role
+	if err := db.Collection("roles").Find(ctx, bson.M{"name": name}).One(&role); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if role.BuiltIn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles can't be deleted"})
+		return
+	}
+
+	assigned, err := db.Collection("users").Find(ctx, bson.M{"roles": name}).Count()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role assignments"})
+		return
+	}
+	if assigned > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Role is assigned to %d user(s)", assigned)})
+		return
+	}
+
+	if err := db.Collection("roles").Remove(ctx, bson.M{"_id": role.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+// checkGrantedPermissions writes a forbidden error and returns false unless
+// the admin holds every one of the permissions, so that roles can't be used
+// to grant permissions the admin doesn't have
+func checkGrantedPermissions(c *gin.Context, permissions []string) bool {
+	value, _ := c.Get("permissions")
+	granted, _ := value.(map[string]bool)
+	for _, permission := range permissions {
+		if !granted[permission] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + permission + " required to change this role"})
+			return false
+		}
+	}
+	return true
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !models.IsKnownPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+	return nil
+}
+
+// validateRoleNames checks that every role exists
+func validateRoleNames(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var existing []string
+	if err := database.GetDB().Collection("roles").Find(ctx, bson.M{"name": bson.M{"$in": names}}).Distinct("name", &existing); err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		known[name] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("%w %q", errUnknownRole, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"backend-order/middleware"
+)
+
+const basePath = "/admin"
+
+var (
+	guardedMu     sync.Mutex
+	guardedRoutes = map[string]bool{}
+)
+
+// Router registers routes under /admin behind authentication and a
+// permission check. Every admin route file registers through it so the check
+// can't be forgotten, and VerifyRoutes can tell which routes went through it.
+type Router struct {
+	group *gin.RouterGroup
+}
+
+// NewRouter returns a Router for the /admin routes of the engine
+func NewRouter(r *gin.Engine) *Router {
+	return &Router{
+		group: r.Group(basePath, middleware.AuthMiddleware()),
+	}
+}
+
+// Handle registers an admin route only reachable by users granted permission
+func (a *Router) Handle(method, path, permission string, handlers ...gin.HandlerFunc) {
+	if permission == "" {
+		panic(fmt.Sprintf("admin route %s registered without a permission", routeKey(method, a.group.BasePath()+path)))
+	}
+	a.group.Handle(method, path, append([]gin.HandlerFunc{middleware.RequirePermission(permission)}, handlers...)...)
+
+	guardedMu.Lock()
+	defer guardedMu.Unlock()
+	guardedRoutes[routeKey(method, a.group.BasePath()+path)] = true
+}
+
+func (a *Router) GET(path, permission string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodGet, path, permission, handlers...)
+}
+
+func (a *Router) POST(path, permission string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodPost, path, permission, handlers...)
+}
+
+func (a *Router) PUT(path, permission string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodPut, path, permission, handlers...)
+}
+
+func (a *Router) PATCH(path, permission string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodPatch, path, permission, handlers...)
+}
+
+func (a *Router) DELETE(path, permission string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodDelete, path, permission, handlers...)
+}
+
+// VerifyRoutes lists every /admin route registered on the engine and returns
+// an error naming those that were not registered through a Router
+func VerifyRoutes(r *gin.Engine) error {
+	guardedMu.Lock()
+	defer guardedMu.Unlock()
+
+	var unguarded []string
+	for _, route := range r.Routes() {
+		if route.Path != basePath && !strings.HasPrefix(route.Path, basePath+"/") {
+			continue
+		}
+		if !guardedRoutes[routeKey(route.Method, route.Path)] {
+			unguarded = append(unguarded, routeKey(route.Method, route.Path))
+		}
+	}
+
+	if len(unguarded) > 0 {
+		sort.Strings(unguarded)
+		return fmt.Errorf("admin routes registered without a permission check: %s", strings.Join(unguarded, ", "))
+	}
+	return nil
+}
+
+func routeKey(method, path string) string {
+	return method + " " + path
+}
@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupAdminRoutes() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetupAdminProductRoutes(r)
+	SetupAdminInventoryRoutes(r)
+	SetupAdminOrderRoutes(r)
+	SetupAdminUserRoutes(r)
+	SetupAdminRoleRoutes(r)
+	return r
+}
+
+func TestVerifyRoutes(t *testing.T) {
+	r := setupAdminRoutes()
+	if err := VerifyRoutes(r); err != nil {
+		t.Fatalf("VerifyRoutes() = %v, want nil", err)
+	}
+}
+
+func TestVerifyRoutesUnguarded(t *testing.T) {
+	r := setupAdminRoutes()
+	r.Handle(http.MethodGet, "/admin/unguarded", func(c *gin.Context) {})
+	// Routes outside /admin don't need the guard
+	r.Handle(http.MethodGet, "/administrators", func(c *gin.Context) {})
+
+	err := VerifyRoutes(r)
+	if err == nil {
+		t.Fatal("VerifyRoutes() = nil, want an error for GET /admin/unguarded")
+	}
+	if !strings.Contains(err.Error(), "GET /admin/unguarded") || strings.Contains(err.Error(), "/administrators") {
+		t.Errorf("VerifyRoutes() = %v, want only GET /admin/unguarded listed", err)
+	}
+}
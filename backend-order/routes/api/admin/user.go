@@ -2,26 +2,40 @@ package admin
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"backend-order/database"
 	"backend-order/middleware"
 	"backend-order/models"
 
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func SetupAdminUserRoutes(r *gin.Engine) {
-	// Add admin routes
-	adminGroup := r.Group("/admin")
-	adminGroup.Use(middleware.AuthMiddleware(), middleware.AdminOnly())
+	adminGroup := NewRouter(r)
 	{
-		adminGroup.GET("/users", listUsersHandler)
-		adminGroup.GET("/users/:id", getUserDetailsHandler)
-		adminGroup.POST("/users", createUserHandler)
-		adminGroup.PUT("/users/:id", updateUserHandler)
+		adminGroup.GET("/users", models.PermissionUsersRead, listUsersHandler)
+		adminGroup.GET("/users/:id", models.PermissionUsersRead, getUserDetailsHandler)
+		adminGroup.POST("/users", models.PermissionUsersManage, createUserHandler)
+		adminGroup.PUT("/users/:id", models.PermissionUsersManage, updateUserHandler)
+		adminGroup.DELETE("/users/:id", models.PermissionUsersManage, deleteUserHandler)
+		adminGroup.PUT("/users/:id/roles", models.PermissionUsersManage, setUserRolesHandler)
+		adminGroup.POST("/users/:id/roles/:role", models.PermissionUsersManage, grantUserRoleHandler)
+		adminGroup.DELETE("/users/:id/roles/:role", models.PermissionUsersManage, revokeUserRoleHandler)
+		adminGroup.POST("/users/:id/disable", models.PermissionUsersManage, disableUserHandler)
+		adminGroup.POST("/users/:id/enable", models.PermissionUsersManage, enableUserHandler)
+		adminGroup.POST("/users/:id/unlock", models.PermissionUsersManage, unlockUserHandler)
+		adminGroup.DELETE("/users/:id/mfa", models.PermissionUsersManage, resetUserMFAHandler)
+		adminGroup.GET("/users/:id/api-keys", models.PermissionUsersRead, listUserAPIKeysHandler)
+		adminGroup.DELETE("/users/:id/api-keys/:key_id", models.PermissionUsersManage, revokeUserAPIKeyHandler)
+		adminGroup.GET("/users/:id/security-events", models.PermissionUsersRead, listUserSecurityEventsHandler)
 	}
 }
 
@@ -88,60 +102,110 @@ func getUserDetailsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// CreateUserInput defines a user created by an admin
+type CreateUserInput struct {
+	Email    string   `json:"email" binding:"required,email"`
+	Password string   `json:"password" binding:"required,min=6"`
+	Roles    []string `json:"roles"`
+	// EmailVerified skips the verification of the email, for addresses the admin vouches for
+	EmailVerified bool `json:"email_verified"`
+}
+
+// UpdateUserInput defines the changes to a user, only set fields are changed
+type UpdateUserInput struct {
+	Email    *string `json:"email" binding:"omitempty,email"`
+	Password *string `json:"password" binding:"omitempty,min=6"`
+	// EmailVerified defaults to false when the email changes
+	EmailVerified *bool `json:"email_verified"`
+}
+
+// DisableUserInput gives the reason an account is disabled
+type DisableUserInput struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
 // createUserHandler handles creating a new user by admin
 // @Summary Create a new user
-// @Description Create a new user (admin only)
+// @Description Create a new user with a password and roles (admin only)
 // @Tags Admin
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User object"
+// @Param user body CreateUserInput true "User"
 // @Security ApiKeyAuth
 // @Success 201 {object} models.User
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /admin/users [post]
 func createUserHandler(c *gin.Context) {
-	var newUser models.User
-	if err := c.ShouldBindJSON(&newUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
 		return
 	}
 
-	// Use SetPassword method
-	if err := newUser.SetPassword(newUser.Password); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password"})
+	var input CreateUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx := context.Background()
+	if !checkRoleChange(c, ctx, nil, input.Roles) {
+		return
+	}
+
 	db := database.GetDB()
-	result, err := db.Collection("users").InsertOne(ctx, &newUser)
+	count, err := db.Collection("users").Find(ctx, bson.M{"email": input.Email}).Count()
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
+		return
+	}
+
+	newUser := models.User{
+		ID:            primitive.NewObjectID(),
+		Email:         input.Email,
+		Roles:         input.Roles,
+		EmailVerified: input.EmailVerified,
+	}
+	newUser.IsAdmin = newUser.HasRole(models.RoleAdmin)
+	if err := newUser.SetPassword(input.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password"})
+		return
+	}
+
+	if _, err := db.Collection("users").InsertOne(ctx, newUser); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	newUser.ID = result.InsertedID.(primitive.ObjectID)
-	newUser.Password = "" // Don't send the password back
+	if len(newUser.Roles) > 0 {
+		recordUserEvent(ctx, newUser, models.SecurityEventRolesChanged, admin, strings.Join(newUser.Roles, ","))
+	}
+
 	c.JSON(http.StatusCreated, newUser)
 }
 
 // updateUserHandler handles updating an existing user by admin
 // @Summary Update an existing user
-// @Description Update an existing user (admin only)
+// @Description Change the email, password or email verification of a user. A new password revokes every session and API key of the user. Users with permissions the admin doesn't hold can't be changed (admin only)
 // @Tags Admin
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
-// @Param user body models.User true "Updated user object"
+// @Param user body UpdateUserInput true "Fields to change"
 // @Security ApiKeyAuth
 // @Success 200 {object} models.User
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /admin/users/{id} [put]
 func updateUserHandler(c *gin.Context) {
@@ -151,8 +215,8 @@ func updateUserHandler(c *gin.Context) {
 		return
 	}
 
-	var updateUser models.User
-	if err := c.ShouldBindJSON(&updateUser); err != nil {
+	var input UpdateUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -160,32 +224,755 @@ func updateUserHandler(c *gin.Context) {
 	ctx := context.Background()
 	db := database.GetDB()
 
-	// If password is provided, use SetPassword method
-	if updateUser.Password != "" {
-		if err := updateUser.SetPassword(updateUser.Password); err != nil {
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !checkHeldPermissions(c, ctx, user, "update this user") {
+		return
+	}
+
+	updateData := bson.M{}
+	if input.Email != nil && *input.Email != user.Email {
+		count, err := db.Collection("users").Find(ctx, bson.M{"email": *input.Email}).Count()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
+			return
+		}
+		updateData["email"] = *input.Email
+		// The new address hasn't been verified by anyone yet
+		updateData["email_verified"] = false
+	}
+	if input.EmailVerified != nil {
+		updateData["email_verified"] = *input.EmailVerified
+	}
+	if input.Password != nil {
+		var password models.User
+		if err := password.SetPassword(*input.Password); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password"})
 			return
 		}
+		updateData["password"] = password.Password
+	}
+	if len(updateData) == 0 {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+
+	var updated models.User
+	err = db.Collection("users").Find(ctx, bson.M{"_id": userID}).Apply(qmgo.Change{
+		Update:    bson.M{"$set": updateData},
+		ReturnNew: true,
+	}, &updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if input.Password != nil {
+		if err := models.RevokeUserAccess(ctx, db, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing sessions"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// disableUserHandler handles disabling the account of a user
+// @Summary Disable a user
+// @Description Disable an account: the user can't log in, and their sessions and API keys are revoked. The last active admin, and users with permissions the admin doesn't hold, can't be disabled (admin only)
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param reason body DisableUserInput false "Reason"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/disable [post]
+func disableUserHandler(c *gin.Context) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
 	}
 
-	updateData := bson.M{
-		"email":    updateUser.Email,
-		"password": updateUser.Password, // Only if password was updated
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
 	}
 
-	// Remove empty fields
-	for k, v := range updateData {
-		if v == "" {
-			delete(updateData, k)
+	var input DisableUserInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 	}
 
-	err = db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": updateData})
+	if userID == admin.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't disable your own account"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+	users := db.Collection("users")
+
+	var user models.User
+	if err := users.Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+	if !checkHeldPermissions(c, ctx, user, "disable this user") {
+		return
+	}
+
+	var updated models.User
+	err = removeUnlessLastAdmin(ctx, userID, func(sessCtx context.Context) error {
+		return users.Find(sessCtx, bson.M{"_id": userID}).Apply(qmgo.Change{
+			Update: bson.M{"$set": bson.M{
+				"disabled":        true,
+				"disabled_at":     time.Now(),
+				"disabled_reason": input.Reason,
+			}},
+			ReturnNew: true,
+		}, &updated)
+	})
+	if errors.Is(err, models.ErrLastAdmin) {
+		respondLastAdmin(c)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable user"})
+		return
+	}
+
+	if err := models.RevokeUserAccess(ctx, db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	recordUserEvent(ctx, updated, models.SecurityEventAccountDisabled, admin, input.Reason)
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// enableUserHandler handles enabling a disabled account
+// @Summary Enable a user
+// @Description Enable a disabled account. The user has to log in again (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/enable [post]
+func enableUserHandler(c *gin.Context) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var updated models.User
+	err = database.GetDB().Collection("users").Find(ctx, bson.M{"_id": userID}).Apply(qmgo.Change{
+		Update: bson.M{
+			"$set":   bson.M{"disabled": false},
+			"$unset": bson.M{"disabled_at": "", "disabled_reason": ""},
+		},
+		ReturnNew: true,
+	}, &updated)
+	if err == qmgo.ErrNoSuchDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable user"})
+		return
+	}
+
+	recordUserEvent(ctx, updated, models.SecurityEventAccountEnabled, admin, "")
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// deleteUserHandler handles deleting a user
+// @Summary Delete a user
+// @Description Delete a user with their sessions, API keys and cart. Their orders are kept for accounting but anonymised. The last active admin, and users with permissions the admin doesn't hold, can't be deleted (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id} [delete]
+func deleteUserHandler(c *gin.Context) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if userID == admin.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't delete your own account"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !checkHeldPermissions(c, ctx, user, "delete this user") {
+		return
+	}
+
+	err = removeUnlessLastAdmin(ctx, userID, func(sessCtx context.Context) error {
+		return models.DeleteUser(sessCtx, db, user)
+	})
+	if errors.Is(err, models.ErrLastAdmin) {
+		respondLastAdmin(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	// The event keeps the ID only, the email is gone with the user
+	user.Email = ""
+	recordUserEvent(ctx, user, models.SecurityEventUserDeleted, admin, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+// removeUnlessLastAdmin runs write, which demotes, disables or deletes the
+// user, in a transaction that fails with models.ErrLastAdmin when the user is
+// the last active admin
+func removeUnlessLastAdmin(ctx context.Context, userID primitive.ObjectID, write func(sessCtx context.Context) error) error {
+	db := database.GetDB()
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		// The user may have been promoted since it was read
+		var user models.User
+		if err := db.Collection("users").Find(sessCtx, bson.M{"_id": userID}).One(&user); err != nil {
+			return nil, err
+		}
+		if user.IsActiveAdmin() {
+			if err := models.EnsureAnotherActiveAdmin(sessCtx, db, userID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, write(sessCtx)
+	}
+	_, err := database.GetClient().DoTransaction(ctx, callback)
+	return err
+}
+
+// respondLastAdmin writes the conflict of a change refused with models.ErrLastAdmin
+func respondLastAdmin(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{"error": "This is the last active admin, promote another admin first"})
+}
+
+// checkRoleChange validates the roles given to a user replacing current
+// ones, and writes an error and returns false if they are unknown or the
+// admin would grant or remove permissions they don't have themselves
+func checkRoleChange(c *gin.Context, ctx context.Context, current, roles []string) bool {
+	if err := validateRoleNames(ctx, roles); err != nil {
+		if errors.Is(err, errUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check roles"})
+		return false
+	}
+
+	changed := roleDifference(roles, current)
+	changed = append(changed, roleDifference(current, roles)...)
+	if len(changed) == 0 {
+		return true
+	}
+
+	return checkHeldPermissions(c, ctx, models.User{Roles: changed}, "change these roles")
+}
+
+// checkHeldPermissions writes a forbidden error and returns false unless the
+// admin holds every permission of the user, so that admins can't take over
+// or lock out accounts more privileged than their own
+func checkHeldPermissions(c *gin.Context, ctx context.Context, user models.User, action string) bool {
+	userPermissions, err := models.ResolvePermissions(ctx, database.GetDB().Collection("roles"), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving permissions"})
+		return false
+	}
+	value, _ := c.Get("permissions")
+	granted, _ := value.(map[string]bool)
+	for permission := range userPermissions {
+		if !granted[permission] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + permission + " required to " + action})
+			return false
+		}
+	}
+	return true
+}
+
+// roleDifference returns the roles of a missing from b
+func roleDifference(a, b []string) []string {
+	missing := []string{}
+	for _, role := range a {
+		found := false
+		for _, other := range b {
+			if other == role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, role)
+		}
+	}
+	return missing
+}
+
+func recordUserEvent(ctx context.Context, user models.User, eventType string, admin models.User, reason string) {
+	err := models.RecordSecurityEvent(ctx, database.GetDB(), models.SecurityEvent{
+		Type:   eventType,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  models.UserActor(admin),
+		Reason: reason,
+	})
+	if err != nil {
+		log.Printf("Failed to record %s of %s: %v", eventType, user.ID.Hex(), err)
+	}
+}
+
+// UserRolesInput defines the roles assigned to a user
+type UserRolesInput struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// setUserRolesHandler handles replacing the roles of a user
+// @Summary Set user roles
+// @Description Replace the roles assigned to a user. Only permissions the admin holds can be granted or removed, and the last active admin can't lose the admin role (admin only)
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param roles body UserRolesInput true "Roles"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/roles [put]
+func setUserRolesHandler(c *gin.Context) {
+	var input UserRolesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	updateUser.Password = "" // Don't send the password back
-	c.JSON(http.StatusOK, updateUser)
+	changeUserRoles(c, func(current []string) []string { return input.Roles })
+}
+
+// grantUserRoleHandler handles promoting a user to a role
+// @Summary Grant a role to a user
+// @Description Add a role to the roles of a user. Only permissions the admin holds can be granted (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param role path string true "Role name"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/roles/{role} [post]
+func grantUserRoleHandler(c *gin.Context) {
+	role := c.Param("role")
+	changeUserRoles(c, func(current []string) []string {
+		return append(roleDifference(current, []string{role}), role)
+	})
+}
+
+// revokeUserRoleHandler handles demoting a user from a role
+// @Summary Revoke a role from a user
+// @Description Remove a role from the roles of a user. The last active admin can't lose the admin role (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param role path string true "Role name"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/roles/{role} [delete]
+func revokeUserRoleHandler(c *gin.Context) {
+	role := c.Param("role")
+	changeUserRoles(c, func(current []string) []string {
+		return roleDifference(current, []string{role})
+	})
+}
+
+// changeUserRoles replaces the roles of the user of the id parameter with
+// the roles returned by change, checking that the admin may make the change
+// and that an active admin remains
+func changeUserRoles(c *gin.Context, change func(current []string) []string) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	users := database.GetDB().Collection("users")
+
+	var user models.User
+	if err := users.Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	current := user.RoleNames()
+	roles := change(current)
+	if !checkRoleChange(c, ctx, current, roles) {
+		return
+	}
+
+	// isAdmin is kept in sync with the admin role for clients reading the flag
+	isAdmin := models.User{Roles: roles}.HasRole(models.RoleAdmin)
+
+	var updated models.User
+	write := func(ctx context.Context) error {
+		return users.Find(ctx, bson.M{"_id": userID}).Apply(qmgo.Change{
+			Update: bson.M{"$set": bson.M{
+				"roles":   roles,
+				"isAdmin": isAdmin,
+			}},
+			ReturnNew: true,
+		}, &updated)
+	}
+	if isAdmin {
+		err = write(ctx)
+	} else {
+		err = removeUnlessLastAdmin(ctx, userID, write)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrLastAdmin) {
+			respondLastAdmin(c)
+			return
+		}
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user roles"})
+		return
+	}
+
+	if len(roleDifference(current, roles))+len(roleDifference(roles, current)) > 0 {
+		recordUserEvent(ctx, updated, models.SecurityEventRolesChanged, admin, strings.Join(roles, ","))
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// unlockUserHandler handles lifting the login lockout of a user
+// @Summary Unlock a user
+// @Description Clear the failed logins and lockout of a user (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/unlock [post]
+func unlockUserHandler(c *gin.Context) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := models.ResetLoginFailures(ctx, db.Collection("login_throttles"), models.AccountThrottleKey(user.Email)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	err = models.RecordSecurityEvent(ctx, db, models.SecurityEvent{
+		Type:   models.SecurityEventAccountUnlocked,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  models.UserActor(admin),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record unlock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// resetUserMFAHandler handles removing the second factor of a user who lost it
+// @Summary Reset user two-factor authentication
+// @Description Disable two-factor authentication of a user who lost their authenticator and recovery codes, and revoke their sessions and API keys. Requires every permission of the user (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/mfa [delete]
+func resetUserMFAHandler(c *gin.Context) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !checkHeldPermissions(c, ctx, user, "reset this user's two-factor authentication") {
+		return
+	}
+
+	if err := models.DisableMFA(ctx, db.Collection("users"), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	if err := models.RevokeUserAccess(ctx, db, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	err = models.RecordSecurityEvent(ctx, db, models.SecurityEvent{
+		Type:   models.SecurityEventMFAReset,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  models.UserActor(admin),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record reset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
+
+// listUserAPIKeysHandler handles retrieving the API keys of a user
+// @Summary List user API keys
+// @Description Retrieve the API keys of a user with their last use, newest first (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} models.APIKey
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/api-keys [get]
+func listUserAPIKeysHandler(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	keys, err := models.ListAPIKeys(context.Background(), database.GetDB().Collection("api_keys"), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeUserAPIKeyHandler handles revoking an API key of a user
+// @Summary Revoke a user API key
+// @Description Revoke an API key of a user, for instance when it leaked (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param key_id path string true "API key ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/api-keys/{key_id} [delete]
+func revokeUserAPIKeyHandler(c *gin.Context) {
+	admin, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	keyID, err := primitive.ObjectIDFromHex(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	err = models.RevokeAPIKey(ctx, db.Collection("api_keys"), user.ID, keyID)
+	if err == models.ErrInvalidAPIKey {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	err = models.RecordSecurityEvent(ctx, db, models.SecurityEvent{
+		Type:   models.SecurityEventAPIKeyRevoked,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  models.UserActor(admin),
+		Reason: "API key " + keyID.Hex(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record revocation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// listUserSecurityEventsHandler handles retrieving the security events of a user
+// @Summary List user security events
+// @Description Retrieve the lockouts and other security events of a user, newest first (admin only)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} models.SecurityEvent
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/security-events [get]
+func listUserSecurityEventsHandler(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var events []models.SecurityEvent
+	err = database.GetDB().Collection("security_events").
+		Find(context.Background(), bson.M{"user_id": userID}).
+		Sort("-created_at").
+		Limit(100).
+		All(&events)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching security events"})
+		return
+	}
+
+	if events == nil {
+		events = []models.SecurityEvent{}
+	}
+
+	c.JSON(http.StatusOK, events)
 }
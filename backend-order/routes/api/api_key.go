@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"backend-order/database"
+	"backend-order/middleware"
+	"backend-order/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,required"`
+	// ExpiresInDays defaults to 90 days, and can't exceed 365
+	ExpiresInDays int `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// CreateAPIKeyResponse returns the key in clear, which is never shown again
+type CreateAPIKeyResponse struct {
+	Key string `json:"key"`
+	models.APIKey
+}
+
+// SetupAPIKeyRoutes sets up the routes managing the API keys of the current user
+func SetupAPIKeyRoutes(r *gin.Engine) {
+	keyGroup := r.Group("/api-keys")
+	keyGroup.Use(middleware.AuthMiddleware(), middleware.SessionOnly())
+	{
+		keyGroup.GET("", listAPIKeysHandler)
+		keyGroup.POST("", createAPIKeyHandler)
+		keyGroup.DELETE("/:id", revokeAPIKeyHandler)
+	}
+}
+
+// @Summary List my API keys
+// @Description List the API keys of the authenticated user, including revoked and expired ones, newest first
+// @Tags API Keys
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api-keys [get]
+func listAPIKeysHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	keys, err := models.ListAPIKeys(context.Background(), database.GetDB().Collection("api_keys"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// @Summary Create an API key
+// @Description Create a named, scoped and expiring API key for machine clients, sent in the Authorization header instead of an access token. The key is only returned once. Scopes are orders:read and orders:write for the user's own cart and orders, and permissions granted by the user's roles for the admin API, which require a session opened with two-factor authentication
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param key body CreateAPIKeyRequest true "API key"
+// @Security ApiKeyAuth
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api-keys [post]
+func createAPIKeyHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	granted, err := models.ResolvePermissions(ctx, db.Collection("roles"), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving permissions"})
+		return
+	}
+
+	adminScopes := false
+	for _, scope := range req.Scopes {
+		if !models.IsKnownPermission(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+		if models.IsUserScope(scope) {
+			continue
+		}
+		if !granted[scope] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + scope + " required for this scope"})
+			return
+		}
+		adminScopes = true
+	}
+
+	// Keys with admin scopes must not be a way around the second factor
+	mfa := c.GetBool("mfa")
+	if adminScopes && middleware.AdminMFARequired() && !mfa {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for keys with admin scopes"})
+		return
+	}
+
+	lifetime := models.APIKeyDefaultLifetime
+	if req.ExpiresInDays > 0 {
+		lifetime = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+
+	secret, key, err := models.CreateAPIKey(ctx, db.Collection("api_keys"), models.APIKey{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		MFA:       mfa,
+		ExpiresAt: time.Now().Add(lifetime),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	recordAPIKeyEvent(ctx, user, key, models.SecurityEventAPIKeyCreated, models.UserActor(user))
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{Key: secret, APIKey: key})
+}
+
+// @Summary Revoke an API key
+// @Description Revoke one of the API keys of the authenticated user. Revoking a revoked key succeeds
+// @Tags API Keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api-keys/{id} [delete]
+func revokeAPIKeyHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	ctx := context.Background()
+	err = models.RevokeAPIKey(ctx, database.GetDB().Collection("api_keys"), user.ID, keyID)
+	if err == models.ErrInvalidAPIKey {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	recordAPIKeyEvent(ctx, user, models.APIKey{ID: keyID}, models.SecurityEventAPIKeyRevoked, models.UserActor(user))
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+func recordAPIKeyEvent(ctx context.Context, user models.User, key models.APIKey, eventType, actor string) {
+	err := models.RecordSecurityEvent(ctx, database.GetDB(), models.SecurityEvent{
+		Type:   eventType,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  actor,
+		Reason: "API key " + key.ID.Hex(),
+	})
+	if err != nil {
+		log.Printf("Failed to record %s of %s: %v", eventType, user.Email, err)
+	}
+}
@@ -5,31 +5,47 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"backend-order/database"
+	"backend-order/helpers"
+	"backend-order/middleware"
 	"backend-order/models"
 	"backend-order/vendors"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var (
-	secretKey = []byte("your_secret_key") // TODO: Use environment variable in production
-)
-
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the lifetime of the access token in seconds
+	ExpiresIn int64 `json:"expires_in"`
+	// MFAEnrollmentRequired tells admins that they must enroll an
+	// authenticator app before using the admin API
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+// MFAChallengeResponse is returned by /auth/login instead of tokens when the
+// account has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired bool `json:"mfa_required"`
+	// MFAToken is sent to /auth/login/mfa along with the code
+	MFAToken string `json:"mfa_token"`
 }
 
 type RegisterUserRequest struct {
@@ -38,7 +54,8 @@ type RegisterUserRequest struct {
 }
 
 type ResetPasswordRequest struct {
-	Email       string `json:"email" binding:"required,email"`
+	// Email is optional, when given it must be the email of the account
+	Email       string `json:"email" binding:"omitempty,email"`
 	ResetToken  string `json:"resetToken" binding:"required"`
 	NewPassword string `json:"newPassword" binding:"required,min=6"`
 }
@@ -48,25 +65,59 @@ type ForgotPasswordRequest struct {
 }
 
 func SetupAuthRoutes(r *gin.Engine) {
+	// Invalid single sign-on settings stop the service at startup rather than at the first login
+	helpers.OIDC()
+	ssoRoles()
+
 	authGroup := r.Group("/auth")
 	{
 		authGroup.POST("/login", loginHandler)
+		authGroup.POST("/login/mfa", loginMFAHandler)
+		authGroup.POST("/oidc/authorize", oidcAuthorizeHandler)
+		authGroup.POST("/oidc/callback", oidcCallbackHandler)
 		authGroup.POST("/register", registerUserHandler)
 		authGroup.POST("/reset-password", resetPasswordHandler)   // New endpoint
 		authGroup.POST("/forgot-password", forgotPasswordHandler) // New endpoint
+		authGroup.POST("/verify-email", verifyEmailHandler)
+		authGroup.POST("/resend-verification", resendVerificationHandler)
+		authGroup.POST("/refresh", refreshHandler)
+		authGroup.POST("/logout", logoutHandler)
+		authGroup.POST("/logout-all", middleware.AuthMiddleware(), middleware.SessionOnly(), logoutAllHandler)
+
+		mfaGroup := authGroup.Group("/mfa", middleware.AuthMiddleware(), middleware.SessionOnly())
+		mfaGroup.POST("/enroll", mfaEnrollHandler)
+		mfaGroup.POST("/verify", mfaVerifyHandler)
+		mfaGroup.POST("/disable", mfaDisableHandler)
+		mfaGroup.POST("/recovery-codes", mfaRecoveryCodesHandler)
 	}
+
+	r.GET("/.well-known/jwks.json", jwksHandler)
+}
+
+// jwksHandler publishes the public keys access tokens are verified with
+// @Summary JSON Web Key Set
+// @Description List the public keys of the asymmetric token signing keys so other services can verify access tokens
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} helpers.JWKS
+// @Router /.well-known/jwks.json [get]
+func jwksHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, helpers.JWTKeys().JWKS())
 }
 
 // loginHandler handles user authentication and JWT token generation
 // @Summary User login
-// @Description Authenticate a user and return a JWT token
+// @Description Authenticate a user and return a short-lived JWT access token with a refresh token. When two-factor authentication is enabled, an MFAChallengeResponse is returned instead, to complete with /auth/login/mfa
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param loginRequest body LoginRequest true "Login credentials"
 // @Success 200 {object} LoginResponse
+// @Success 200 {object} MFAChallengeResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /auth/login [post]
 func loginHandler(c *gin.Context) {
@@ -76,6 +127,13 @@ func loginHandler(c *gin.Context) {
 		return
 	}
 
+	ctx := context.Background()
+	db := database.GetDB()
+
+	if !countLoginAttempt(c, ctx, db, loginReq.Email) {
+		return
+	}
+
 	user, err := authenticateUser(loginReq.Email, loginReq.Password)
 	if err != nil {
 		fmt.Println("Authentication failed:", err)
@@ -83,18 +141,114 @@ func loginHandler(c *gin.Context) {
 		return
 	}
 
-	token, err := generateJWTToken(*user)
+	forgiveLoginAttempt(ctx, db, loginReq.Email, c.ClientIP())
+
+	// Only told once the password is known, so disabled accounts can't be probed
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	if user.MFAEnabled {
+		mfaToken, err := signMFAPendingToken(*user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	response, err := issueTokens(c, *user, primitive.NilObjectID, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 		return
 	}
+	response.MFAEnrollmentRequired = middleware.AdminMFARequired() && len(user.RoleNames()) > 0
 
-	c.JSON(http.StatusOK, LoginResponse{Token: token})
+	c.JSON(http.StatusOK, response)
+}
+
+// countLoginAttempt counts the attempt as a failure against the account and
+// the IP before the credentials are checked, and records a security event
+// when either gets locked. It writes an error and returns false when the
+// attempt has to wait.
+func countLoginAttempt(c *gin.Context, ctx context.Context, db *qmgo.Database, email string) bool {
+	throttles := db.Collection("login_throttles")
+	ip := c.ClientIP()
+
+	wait, locked, err := models.CountLoginAttempt(ctx, throttles, models.AccountThrottleKey(email), models.AccountThrottlePolicy)
+	if err != nil {
+		log.Printf("Failed to record login attempt of %s: %v", email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed"})
+		return false
+	}
+	if locked {
+		event := models.SecurityEvent{
+			Type:   models.SecurityEventAccountLocked,
+			Email:  email,
+			IP:     ip,
+			Reason: fmt.Sprintf("%d failed logins", models.AccountThrottlePolicy.LockAfter),
+		}
+		var user models.User
+		if err := db.Collection("users").Find(ctx, bson.M{"email": email}).One(&user); err == nil {
+			event.UserID = &user.ID
+		}
+		if err := models.RecordSecurityEvent(ctx, db, event); err != nil {
+			log.Printf("Failed to record lockout of %s: %v", email, err)
+		}
+	}
+
+	if wait == 0 {
+		var ipWait time.Duration
+		ipWait, locked, err = models.CountLoginAttempt(ctx, throttles, models.IPThrottleKey(ip), models.IPThrottlePolicy)
+		if err != nil {
+			log.Printf("Failed to record login attempt from %s: %v", ip, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed"})
+			return false
+		}
+		if locked {
+			event := models.SecurityEvent{
+				Type:   models.SecurityEventIPLocked,
+				IP:     ip,
+				Reason: fmt.Sprintf("%d failed logins", models.IPThrottlePolicy.LockAfter),
+			}
+			if err := models.RecordSecurityEvent(ctx, db, event); err != nil {
+				log.Printf("Failed to record lockout of %s: %v", ip, err)
+			}
+		}
+		if ipWait > 0 {
+			// The attempt of the account wasn't made after all
+			if err := models.ForgiveLoginAttempt(ctx, throttles, models.AccountThrottleKey(email)); err != nil {
+				log.Printf("Failed to forgive login attempt of %s: %v", email, err)
+			}
+			wait = ipWait
+		}
+	}
+
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+		return false
+	}
+	return true
+}
+
+// forgiveLoginAttempt takes back the attempt counted by countLoginAttempt
+// once the credentials are right, forgetting the failures of the account
+func forgiveLoginAttempt(ctx context.Context, db *qmgo.Database, email, ip string) {
+	throttles := db.Collection("login_throttles")
+	if err := models.ResetLoginFailures(ctx, throttles, models.AccountThrottleKey(email)); err != nil {
+		log.Printf("Failed to reset login failures of %s: %v", email, err)
+	}
+	if err := models.ForgiveLoginAttempt(ctx, throttles, models.IPThrottleKey(ip)); err != nil {
+		log.Printf("Failed to forgive login attempt from %s: %v", ip, err)
+	}
 }
 
 // registerUserHandler handles user registration
 // @Summary Register a new user
-// @Description Register a new user with email and password
+// @Description Register a new user with email and password, and send a link to verify the email
 // @Tags Authentication
 // @Accept json
 // @Produce json
@@ -135,25 +289,17 @@ func registerUserHandler(c *gin.Context) {
 		return
 	}
 
-	// Send welcome email
-	emailData := vendors.EmailData{
-		To:      []vendors.EmailAddress{{Email: user.Email, Name: user.Email}},
-		Subject: "Welcome to Our Service",
-		Text:    fmt.Sprintf("Dear %s,\n\nWelcome to our service! Your account has been successfully created.\n\nBest regards,\nThe Team", user.Email),
+	if err := sendVerificationEmail(user); err != nil {
+		// Log the error, but don't return it to the user, who can ask for a new email
+		fmt.Printf("Failed to send verification email: %v\n", err)
 	}
 
-	err = vendors.SendEmail(emailData)
-	if err != nil {
-		// Log the error, but don't return it to the user
-		fmt.Printf("Failed to send welcome email: %v\n", err)
-	}
-
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully", "user": user})
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully, check your email to verify your address", "user": user})
 }
 
 // resetPasswordHandler handles password reset requests
 // @Summary Reset user password
-// @Description Reset a user's password
+// @Description Reset a user's password with the token of the reset link. The token can be used once, and every session and API key of the user is revoked
 // @Tags Authentication
 // @Accept json
 // @Produce json
@@ -170,37 +316,47 @@ func resetPasswordHandler(c *gin.Context) {
 		return
 	}
 
-	db := database.GetDB()
-	var user models.User
-	err := db.Collection("users").Find(c, bson.M{
-		"email":         req.Email,
-		"resetToken":    req.ResetToken,
-		"resetTokenExp": bson.M{"$gt": time.Now()},
-	}).One(&user)
-
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired reset token"})
+	var newPassword models.User
+	if err := newPassword.SetPassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set new password"})
 		return
 	}
 
-	if err := user.SetPassword(req.NewPassword); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set new password"})
-		return
+	filter := bson.M{
+		"resetTokenHash": models.HashToken(req.ResetToken),
+		"resetTokenExp":  bson.M{"$gt": time.Now()},
+	}
+	if req.Email != "" {
+		filter["email"] = req.Email
 	}
 
-	err = db.Collection("users").UpdateOne(
-		c,
-		bson.M{"_id": user.ID},
-		bson.M{
-			"$set":   bson.M{"password": user.Password},
-			"$unset": bson.M{"resetToken": "", "resetTokenExp": ""},
+	// Consuming the token in the same update as the password change makes it single-use
+	db := database.GetDB()
+	var user models.User
+	err := db.Collection("users").Find(c, filter).Apply(qmgo.Change{
+		Update: bson.M{
+			"$set":   bson.M{"password": newPassword.Password},
+			"$unset": bson.M{"resetTokenHash": "", "resetTokenExp": ""},
 		},
-	)
+	}, &user)
+	if err == qmgo.ErrNoSuchDocuments {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password in database"})
 		return
 	}
 
+	// Whoever knew the old password must not stay logged in
+	if err := models.RevokeUserAccess(c, db, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing sessions"})
+		return
+	}
+	if err := models.ResetLoginFailures(c, db.Collection("login_throttles"), models.AccountThrottleKey(user.Email)); err != nil {
+		log.Printf("Failed to reset login failures of %s: %v", user.Email, err)
+	}
+
 	// Send password reset confirmation email
 	emailData := vendors.EmailData{
 		To:      []vendors.EmailAddress{{Email: user.Email, Name: user.Email}},
@@ -219,15 +375,13 @@ func resetPasswordHandler(c *gin.Context) {
 
 // forgotPasswordHandler initiates the forgot password process
 // @Summary Initiate forgot password process
-// @Description Send a password reset token to the user's email
+// @Description Send a password reset token to the user's email. The response is the same whether or not the account exists
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param forgotRequest body ForgotPasswordRequest true "User's email"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
 // @Router /auth/forgot-password [post]
 func forgotPasswordHandler(c *gin.Context) {
 	var req ForgotPasswordRequest
@@ -236,58 +390,69 @@ func forgotPasswordHandler(c *gin.Context) {
 		return
 	}
 
+	// The answer is the same, and as fast, whether or not the account exists,
+	// so that it can't be used to find out which emails are registered
+	go func(email string) {
+		if err := sendResetToken(context.Background(), email); err != nil {
+			log.Printf("Failed to initiate password reset of %s: %v", email, err)
+		}
+	}(req.Email)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an account exists for this email, password reset instructions have been sent.",
+	})
+}
+
+// sendResetToken stores a reset token for the account of the email, if any,
+// and sends it by email
+func sendResetToken(ctx context.Context, email string) error {
 	db := database.GetDB()
 	var user models.User
-	err := db.Collection("users").Find(c, bson.M{"email": req.Email}).One(&user)
+	err := db.Collection("users").Find(ctx, bson.M{"email": email}).One(&user)
+	if err == qmgo.ErrNoSuchDocuments {
+		return nil
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
+		return err
 	}
 
 	// Generate a reset token
 	resetToken, err := generateResetToken()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reset token"})
-		return
+		return err
 	}
 
 	// Store the reset token in the database
 	err = db.Collection("users").UpdateOne(
-		c,
+		ctx,
 		bson.M{"_id": user.ID},
 		bson.M{
+			// A newer token replaces the previous one, which stops working
 			"$set": bson.M{
-				"resetToken":    resetToken,
-				"resetTokenExp": time.Now().Add(15 * time.Minute), // Token expires in 15 minutes
+				"resetTokenHash": models.HashToken(resetToken),
+				"resetTokenExp":  time.Now().Add(15 * time.Minute), // Token expires in 15 minutes
 			},
+			// Tokens used to be stored in clear under resetToken
+			"$unset": bson.M{"resetToken": ""},
 		},
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store reset token"})
-		return
+		return err
 	}
 
-	// Send email to the user with the reset token
+	// Send email to the user with the reset link
+	link := helpers.FrontendLink("/reset-password", url.Values{"token": {resetToken}})
 	emailData := vendors.EmailData{
 		To:      []vendors.EmailAddress{{Email: user.Email, Name: user.Email}},
 		Subject: "Password Reset Request",
-		Text:    fmt.Sprintf("Your password reset token is: %s\nThis token will expire in 15 minutes.", resetToken),
+		Text:    fmt.Sprintf("To reset your password, open this link:\n\n%s\n\nThe link expires in 15 minutes. If you did not ask for a password reset, you can ignore this email.", link),
 	}
 
-	err = vendors.SendEmail(emailData)
-	if err != nil {
-		fmt.Println("Failed to send reset token email:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset token email"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Password reset initiated. Check your email for further instructions.",
-	})
+	return vendors.SendEmail(emailData)
 }
 
 func generateResetToken() (string, error) {
-	b := make([]byte, 16)
+	b := make([]byte, 32)
 	_, err := rand.Read(b)
 	if err != nil {
 		return "", err
@@ -295,6 +460,9 @@ func generateResetToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// dummyPasswordHash is compared against when the email is unknown
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 func authenticateUser(email, password string) (*models.User, error) {
 	db := database.GetDB()
 	var user models.User
@@ -302,6 +470,8 @@ func authenticateUser(email, password string) (*models.User, error) {
 	filter := bson.M{"email": email}
 	err := db.Collection("users").Find(context.Background(), filter).One(&user)
 	if err != nil {
+		// Spend as long as for a wrong password so timing doesn't reveal the account exists
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
@@ -311,14 +481,3 @@ func authenticateUser(email, password string) (*models.User, error) {
 
 	return &user, nil
 }
-
-func generateJWTToken(user models.User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":      user.ID.Hex(),
-		"email":   user.Email,
-		"isAdmin": user.IsAdmin,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	return token.SignedString(secretKey)
-}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"backend-order/database"
+	"backend-order/middleware"
+	"backend-order/models"
+)
+
+// IntrospectionResponse describes who an access token or API key belongs to
+// and what it may do
+type IntrospectionResponse struct {
+	UserID        string `json:"user_id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	// Permissions are those granted by the roles of the user, restricted to
+	// the scopes of the API key
+	Permissions []string `json:"permissions"`
+	// Scopes are set for API keys only, access tokens are not scoped
+	Scopes []string `json:"scopes,omitempty"`
+	APIKey bool     `json:"api_key"`
+	// MFA tells whether the session was opened with two-factor
+	// authentication, which admin access requires when AdminMFARequired is set
+	MFA              bool `json:"mfa"`
+	AdminMFARequired bool `json:"admin_mfa_required"`
+}
+
+// requireSignature only lets through requests signed by another service
+func requireSignature(c *gin.Context) {
+	if _, ok := verifySignedRequest(c); !ok {
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// @Summary Introspect a credential
+// @Description Authenticate the access token or API key of the Authorization header the way the API does, and describe its user and permissions, for other services to authorize their callers (backend communication)
+// @Tags Backend
+// @Produce json
+// @Param Authorization header string true "Access token or API key of the caller"
+// @Success 200 {object} IntrospectionResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /backend/auth/introspect [post]
+func handleIntrospect(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	granted, err := models.ResolvePermissions(context.Background(), database.GetDB().Collection("roles"), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving permissions"})
+		return
+	}
+
+	response := IntrospectionResponse{
+		UserID:           user.ID.Hex(),
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Permissions:      []string{},
+		MFA:              c.GetBool("mfa"),
+		AdminMFARequired: middleware.AdminMFARequired(),
+	}
+
+	key, isAPIKey := middleware.CurrentAPIKey(c)
+	for permission := range granted {
+		if isAPIKey && !key.HasScope(permission) {
+			continue
+		}
+		response.Permissions = append(response.Permissions, permission)
+	}
+	sort.Strings(response.Permissions)
+	if isAPIKey {
+		response.APIKey = true
+		response.Scopes = key.Scopes
+	}
+
+	c.JSON(http.StatusOK, response)
+}
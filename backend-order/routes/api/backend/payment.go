@@ -2,18 +2,21 @@ package backend
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"backend-order/database"
 	"backend-order/helpers"
+	"backend-order/middleware"
 	"backend-order/models"
 )
 
@@ -22,6 +25,10 @@ func SetupBackendPaymentRoutes(r *gin.Engine) {
 	backendGroup := r.Group("/backend")
 	{
 		backendGroup.POST("/payment-update", handlePaymentUpdate)
+		backendGroup.GET("/orders/:id/payable", handlePayableOrder)
+		backendGroup.POST("/orders/:id/payment-started", handlePaymentStarted)
+		backendGroup.POST("/refund-update", handleRefundUpdate)
+		backendGroup.POST("/auth/introspect", requireSignature, middleware.AuthMiddleware(), handleIntrospect)
 	}
 }
 
@@ -29,28 +36,156 @@ type PaymentUpdateRequest struct {
 	OrderID string  `json:"order_id" binding:"required"`
 	Status  string  `json:"status" binding:"required"`
 	Amount  float64 `json:"amount" binding:"required"`
+	// Currency defaults to the currency of orders
+	Currency string `json:"currency"`
+}
+
+// PayableOrderResponse is what backend-payment needs to charge an order
+type PayableOrderResponse struct {
+	OrderID  string  `json:"order_id"`
+	UserID   string  `json:"user_id,omitempty"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Status   string  `json:"status"`
+	// Payable is false once the order is paid, cancelled or failed
+	Payable bool `json:"payable"`
+}
+
+// verifySignedRequest reads the body of a request from another service and
+// checks its signature, responding with an error when it can't be trusted
+func verifySignedRequest(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	if !helpers.VerifySignature(c.Request, body) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return nil, false
+	}
+	return body, true
+}
+
+// @Summary Get the payable amount of an order
+// @Description Get the amount, currency and status of an order, for backend-payment to charge the order total rather than an amount sent by the browser (backend communication)
+// @Tags Backend
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} PayableOrderResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /backend/orders/{id}/payable [get]
+func handlePayableOrder(c *gin.Context) {
+	if _, ok := verifySignedRequest(c); !ok {
+		return
+	}
+
+	orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	err = database.GetDB().Collection("orders").Find(c, bson.M{"_id": orderID}).One(&order)
+	if err == qmgo.ErrNoSuchDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching order [%s]: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payableOrderResponse(order))
+}
+
+func payableOrderResponse(order models.Order) PayableOrderResponse {
+	response := PayableOrderResponse{
+		OrderID:  order.ID.Hex(),
+		Amount:   order.TotalAmount,
+		Currency: order.PayableCurrency(),
+		Status:   order.Status,
+		Payable:  order.IsPayable(),
+	}
+	if !order.UserID.IsZero() {
+		response.UserID = order.UserID.Hex()
+	}
+	return response
+}
+
+// @Summary Mark an order as being paid
+// @Description Move a Created order to PaymentPending before backend-payment charges it, so that it isn't expired or cancelled while the charge runs. Repeats for an order already in PaymentPending succeed (backend communication)
+// @Tags Backend
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} PayableOrderResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /backend/orders/{id}/payment-started [post]
+func handlePaymentStarted(c *gin.Context) {
+	if _, ok := verifySignedRequest(c); !ok {
+		return
+	}
+
+	orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	collection := database.GetDB().Collection("orders")
+	var order models.Order
+	err = collection.Find(c, bson.M{"_id": orderID}).One(&order)
+	if err == nil && order.Status == models.OrderStatusCreated {
+		err = models.TransitionOrder(c, collection, &order, models.OrderTransition{
+			To:    models.OrderStatusPaymentPending,
+			Actor: models.ActorPaymentService,
+			Event: "Payment Started",
+		})
+	}
+
+	switch {
+	case err == qmgo.ErrNoSuchDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, models.ErrOrderStatusConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order was updated concurrently"})
+		return
+	case err != nil:
+		log.Printf("Error starting payment of order [%s]: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
+	case order.Status != models.OrderStatusPaymentPending:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Order is %s and can't be paid", order.Status)})
+		return
+	}
+
+	c.JSON(http.StatusOK, payableOrderResponse(order))
 }
 
 // @Summary Update order payment status
-// @Description Update the payment status of an order (backend communication)
+// @Description Update the payment status of an order (backend communication). A completed payment only confirms the order when its amount and currency match the order total; otherwise a Payment Mismatch event is recorded and 409 returned. A completed payment sent again once the order recorded it is answered with 200
 // @Tags Backend
 // @Accept json
 // @Produce json
 // @Param payment body PaymentUpdateRequest true "Payment update details"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /backend/payment-update [post]
 func handlePaymentUpdate(c *gin.Context) {
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
-		return
-	}
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-
-	if !helpers.VerifySignature(c.Request, body) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+	if _, ok := verifySignedRequest(c); !ok {
 		return
 	}
 
@@ -69,48 +204,75 @@ func handlePaymentUpdate(c *gin.Context) {
 		return
 	}
 
-	now := time.Now()
-	var update bson.M
+	var order models.Order
+	err = collection.Find(c, bson.M{"_id": orderID}).One(&order)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("Order [%s] not found", req.OrderID)})
+			return
+		}
+		log.Printf("Error fetching order [%s]: %v", req.OrderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Order [%s] payment status update failed: %v", req.OrderID, err)})
+		return
+	}
 
-	if req.Status == "Completed" {
-		update = bson.M{
-			"$set": bson.M{
-				"status":      models.OrderStatusConfirmed,
-				"paid_amount": req.Amount,
-				"updated_at":  now,
-			},
-			"$push": bson.M{
-				"timeline": models.TimelineEvent{
-					Name:      "Payment Completed",
-					Timestamp: now,
-				},
-			},
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	if req.Status == "Completed" && !order.MatchesPayment(req.Amount, req.Currency) {
+		err = models.RecordOrderEvent(c, collection, &order, models.TimelineEvent{
+			Name:  "Payment Mismatch",
+			Actor: models.ActorPaymentService,
+			Reason: fmt.Sprintf("Paid %.2f %s, expected %.2f %s",
+				req.Amount, strings.ToUpper(req.Currency), order.TotalAmount, order.PayableCurrency()),
+		})
+		if err != nil {
+			log.Printf("Error recording payment mismatch of order [%s]: %v", req.OrderID, err)
 		}
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("Order [%s] payment doesn't match the order total", req.OrderID)})
+		return
+	}
+
+	// The payment service sends outcomes again until it knows they were recorded
+	if req.Status == "Completed" && order.PaidAmount != 0 &&
+		order.Status != models.OrderStatusCreated && order.Status != models.OrderStatusPaymentPending {
+		c.JSON(http.StatusOK, gin.H{"message": "Order payment status already recorded"})
+		return
+	}
+
+	if req.Status == "Completed" {
+		err = models.TransitionOrder(c, collection, &order, models.OrderTransition{
+			To:    models.OrderStatusConfirmed,
+			Actor: models.ActorPaymentService,
+			Event: "Payment Completed",
+			Set:   bson.M{"paid_amount": req.Amount},
+		})
+	} else if order.Status == models.OrderStatusPaymentPending {
+		// The order can be paid again, or expire
+		err = models.TransitionOrder(c, collection, &order, models.OrderTransition{
+			To:     models.OrderStatusCreated,
+			Actor:  models.ActorPaymentService,
+			Event:  "Payment Failed",
+			Reason: fmt.Sprintf("Payment status %s", req.Status),
+		})
 	} else {
 		// If payment failed, don't change the order status
-		update = bson.M{
-			"$set": bson.M{
-				"updated_at": now,
-			},
-			"$push": bson.M{
-				"timeline": models.TimelineEvent{
-					Name:      "Payment Failed",
-					Timestamp: now,
-				},
-			},
-		}
+		err = models.RecordOrderEvent(c, collection, &order, models.TimelineEvent{
+			Name:   "Payment Failed",
+			Actor:  models.ActorPaymentService,
+			Reason: fmt.Sprintf("Payment status %s", req.Status),
+		})
 	}
 
-	result, err := collection.UpdateAll(c, bson.M{"_id": orderID}, update)
-
 	if err != nil {
 		log.Printf("Error updating order [%s] payment status: %v", req.OrderID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Order [%s] payment status update failed: %v", req.OrderID, err)})
-		return
-	}
-
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("Order [%s] not found", req.OrderID)})
+		switch {
+		case errors.Is(err, models.ErrInvalidOrderTransition), errors.Is(err, models.ErrOrderStatusConflict):
+			c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("Order [%s] can't be confirmed: %v", req.OrderID, err)})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Order [%s] payment status update failed: %v", req.OrderID, err)})
+		}
 		return
 	}
 
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-order/database"
+	"backend-order/models"
+)
+
+var errRefundMismatch = errors.New("refund currency doesn't match the order")
+
+type RefundUpdateRequest struct {
+	OrderID  string  `json:"order_id" binding:"required"`
+	RefundID string  `json:"refund_id" binding:"required"`
+	Amount   float64 `json:"amount" binding:"required,gt=0"`
+	// RefundedTotal is the sum of all refunds of the order, this one included
+	RefundedTotal float64 `json:"refunded_total" binding:"required,gt=0"`
+	// Currency defaults to the currency of orders
+	Currency string `json:"currency"`
+	Reason   string `json:"reason"`
+	// Restock puts the items back in stock when the order is fully refunded
+	Restock bool `json:"restock"`
+}
+
+// @Summary Record an order refund
+// @Description Record a refund of the order payment (backend communication). Refunds that reach the order total move it to Refunded. Partial refunds only set its refund_status to PartiallyRefunded, so that it is still shipped. Items go back in stock when restock is requested with a full refund. A refund already recorded is acknowledged without changes
+// @Tags Backend
+// @Accept json
+// @Produce json
+// @Param refund body RefundUpdateRequest true "Refund details"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /backend/refund-update [post]
+func handleRefundUpdate(c *gin.Context) {
+	if _, ok := verifySignedRequest(c); !ok {
+		return
+	}
+
+	var req RefundUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	orderID, err := primitive.ObjectIDFromHex(req.OrderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	alreadyRefunded := false
+
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		db := database.GetDB()
+		orders := db.Collection("orders")
+
+		if err := orders.Find(sessCtx, bson.M{"_id": orderID}).One(&order); err != nil {
+			return nil, err
+		}
+
+		alreadyRefunded = order.HasRefunded(req.RefundedTotal)
+		if alreadyRefunded {
+			return order, nil
+		}
+		if !strings.EqualFold(req.Currency, order.PayableCurrency()) {
+			return nil, fmt.Errorf("%w: refund in %s, order in %s", errRefundMismatch, req.Currency, order.PayableCurrency())
+		}
+
+		reason := fmt.Sprintf("Refunded %.2f %s", req.Amount, order.PayableCurrency())
+		if req.Reason != "" {
+			reason += ": " + req.Reason
+		}
+
+		// A partially refunded order keeps being fulfilled
+		if !order.IsFullyRefunded(req.RefundedTotal) {
+			err := models.RecordPartialRefund(sessCtx, orders, &order, req.RefundedTotal, models.TimelineEvent{
+				Name:   "Partially Refunded",
+				Actor:  models.ActorPaymentService,
+				Reason: reason,
+			})
+			return order, err
+		}
+
+		err := models.TransitionOrder(sessCtx, orders, &order, models.OrderTransition{
+			To:     models.OrderStatusRefunded,
+			Actor:  models.ActorPaymentService,
+			Reason: reason,
+			Set: bson.M{
+				"refunded_amount": req.RefundedTotal,
+				"refund_status":   models.RefundStatusRefunded,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Restock {
+			if err := order.ReturnStock(sessCtx, db, models.ActorPaymentService, reason); err != nil {
+				return nil, err
+			}
+		}
+		return order, nil
+	}
+
+	_, err = database.GetClient().DoTransaction(c, callback)
+	if err != nil {
+		log.Printf("Error recording refund %s of order [%s]: %v", req.RefundID, req.OrderID, err)
+		switch {
+		case err == qmgo.ErrNoSuchDocuments:
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Order [%s] not found", req.OrderID)})
+		case errors.Is(err, errRefundMismatch):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrInvalidOrderTransition), errors.Is(err, models.ErrOrderStatusConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Order [%s] can't be refunded: %v", req.OrderID, err)})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Order [%s] refund failed", req.OrderID)})
+		}
+		return
+	}
+
+	if alreadyRefunded {
+		c.JSON(http.StatusOK, gin.H{"message": "Refund already recorded"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Order refund recorded successfully"})
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-order/database"
+	"backend-order/middleware"
+	"backend-order/models"
+)
+
+// SetupCartRoutes sets up the shopping cart routes
+func SetupCartRoutes(r *gin.Engine) {
+	cartGroup := r.Group("/cart")
+	cartGroup.Use(middleware.AuthMiddleware())
+	{
+		cartGroup.GET("", middleware.RequireScope(models.PermissionOrdersRead), getCartHandler)
+		cartGroup.DELETE("", middleware.RequireScope(models.PermissionOrdersWrite), clearCartHandler)
+		cartGroup.POST("/items", middleware.RequireScope(models.PermissionOrdersWrite), addCartItemHandler)
+		cartGroup.PUT("/items/:product_id", middleware.RequireScope(models.PermissionOrdersWrite), updateCartItemHandler)
+		cartGroup.DELETE("/items/:product_id", middleware.RequireScope(models.PermissionOrdersWrite), removeCartItemHandler)
+	}
+}
+
+// CartLine is a cart item with the current product details
+type CartLine struct {
+	ProductID string  `json:"product_id"`
+	Name      string  `json:"name"`
+	Price     float64 `json:"price"`
+	Stocks    int     `json:"stocks"`
+	Quantity  int     `json:"quantity"`
+	Subtotal  float64 `json:"subtotal"`
+}
+
+// CartResponse represents the cart returned to the client
+type CartResponse struct {
+	Items       []CartLine `json:"items"`
+	TotalAmount float64    `json:"total_amount"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// AddCartItemRequest represents the request body for adding a product to the cart
+type AddCartItemRequest struct {
+	ProductID string `json:"product_id" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+}
+
+// UpdateCartItemRequest represents the request body for changing a cart item quantity
+type UpdateCartItemRequest struct {
+	Quantity int `json:"quantity" binding:"required,min=1"`
+}
+
+// @Summary Get my cart
+// @Description Get the shopping cart of the authenticated user
+// @Tags Cart
+// @Security ApiKeyAuth
+// @Produce json
+// @Success 200 {object} CartResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart [get]
+func getCartHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	cart, err := loadCart(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cart"})
+		return
+	}
+
+	respondWithCart(c, cart)
+}
+
+// @Summary Add a product to my cart
+// @Description Add a product to the cart, increasing the quantity if it is already there
+// @Tags Cart
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param item body AddCartItemRequest true "Cart item"
+// @Success 200 {object} CartResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/items [post]
+func addCartItemHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req AddCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !ensureProductPurchasable(c, req.ProductID) {
+		return
+	}
+
+	cart, err := loadCart(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cart"})
+		return
+	}
+
+	found := false
+	for i := range cart.Items {
+		if cart.Items[i].ProductID == req.ProductID {
+			cart.Items[i].Quantity += req.Quantity
+			found = true
+			break
+		}
+	}
+	if !found {
+		cart.Items = append(cart.Items, models.CartItem{ProductID: req.ProductID, Quantity: req.Quantity})
+	}
+
+	if err := saveCart(c, &cart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating cart"})
+		return
+	}
+
+	respondWithCart(c, cart)
+}
+
+// @Summary Update a cart item
+// @Description Set the quantity of a product already in the cart
+// @Tags Cart
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param product_id path string true "Product ID"
+// @Param item body UpdateCartItemRequest true "New quantity"
+// @Success 200 {object} CartResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/items/{product_id} [put]
+func updateCartItemHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart, err := loadCart(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cart"})
+		return
+	}
+
+	productID := c.Param("product_id")
+	found := false
+	for i := range cart.Items {
+		if cart.Items[i].ProductID == productID {
+			cart.Items[i].Quantity = req.Quantity
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not in cart"})
+		return
+	}
+
+	if err := saveCart(c, &cart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating cart"})
+		return
+	}
+
+	respondWithCart(c, cart)
+}
+
+// @Summary Remove a cart item
+// @Description Remove a product from the cart
+// @Tags Cart
+// @Security ApiKeyAuth
+// @Produce json
+// @Param product_id path string true "Product ID"
+// @Success 200 {object} CartResponse
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart/items/{product_id} [delete]
+func removeCartItemHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	cart, err := loadCart(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cart"})
+		return
+	}
+
+	productID := c.Param("product_id")
+	items := make([]models.CartItem, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		if item.ProductID != productID {
+			items = append(items, item)
+		}
+	}
+	if len(items) == len(cart.Items) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not in cart"})
+		return
+	}
+	cart.Items = items
+
+	if err := saveCart(c, &cart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating cart"})
+		return
+	}
+
+	respondWithCart(c, cart)
+}
+
+// @Summary Clear my cart
+// @Description Remove every item from the cart
+// @Tags Cart
+// @Security ApiKeyAuth
+// @Produce json
+// @Success 200 {object} CartResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cart [delete]
+func clearCartHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	cart := models.Cart{UserID: user.ID, Items: []models.CartItem{}}
+	if err := saveCart(c, &cart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error clearing cart"})
+		return
+	}
+
+	respondWithCart(c, cart)
+}
+
+// loadCart returns the cart of the user, or an empty cart if none is stored yet
+func loadCart(ctx context.Context, userID primitive.ObjectID) (models.Cart, error) {
+	var cart models.Cart
+	err := database.GetDB().Collection("carts").Find(ctx, bson.M{"user_id": userID}).One(&cart)
+	if err == qmgo.ErrNoSuchDocuments {
+		return models.Cart{UserID: userID, Items: []models.CartItem{}}, nil
+	}
+	return cart, err
+}
+
+// saveCart stores the cart, creating it on first use
+func saveCart(ctx context.Context, cart *models.Cart) error {
+	cart.UpdatedAt = time.Now()
+	_, err := database.GetDB().Collection("carts").Upsert(ctx, bson.M{"user_id": cart.UserID}, cart)
+	return err
+}
+
+// ensureProductPurchasable writes a 400 or 404 response if the product ID is
+// not a product that can currently be ordered
+func ensureProductPurchasable(c *gin.Context, productID string) bool {
+	objID, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return false
+	}
+
+	var product models.Product
+	err = database.GetDB().Collection("products").Find(c, bson.M{"_id": objID}).One(&product)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching product"})
+		}
+		return false
+	}
+	if !product.IsPurchasable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not available"})
+		return false
+	}
+	return true
+}
+
+// respondWithCart writes the cart with current product names, prices and subtotals
+func respondWithCart(c *gin.Context, cart models.Cart) {
+	ids := make([]primitive.ObjectID, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		if id, err := primitive.ObjectIDFromHex(item.ProductID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	var products []models.Product
+	if len(ids) > 0 {
+		err := database.GetDB().Collection("products").Find(c, bson.M{"_id": bson.M{"$in": ids}}).All(&products)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
+			return
+		}
+	}
+	productsByID := make(map[string]models.Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID.Hex()] = product
+	}
+
+	resp := CartResponse{Items: []CartLine{}, UpdatedAt: cart.UpdatedAt}
+	for _, item := range cart.Items {
+		product, ok := productsByID[item.ProductID]
+		if !ok || !product.IsPurchasable() {
+			// The product was removed from the catalogue, skip it
+			continue
+		}
+		line := CartLine{
+			ProductID: item.ProductID,
+			Name:      product.Name,
+			Price:     product.Price,
+			Stocks:    product.Stocks,
+			Quantity:  item.Quantity,
+			Subtotal:  float64(item.Quantity) * product.Price,
+		}
+		resp.Items = append(resp.Items, line)
+		resp.TotalAmount += line.Subtotal
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,418 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"backend-order/database"
+	"backend-order/helpers"
+	"backend-order/middleware"
+	"backend-order/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	mfaPendingPurpose  = "mfa_pending"
+	mfaPendingLifetime = 5 * time.Minute
+	totpIssuer         = "Order API"
+)
+
+var errInvalidMFAToken = errors.New("invalid mfa token")
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a code of the authenticator app, RecoveryCode one of the recovery codes
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFADisableRequest struct {
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	// OTPAuthURI is usually shown as a QR code to scan with the authenticator app
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFAVerifyResponse struct {
+	// RecoveryCodes are shown once, each can replace a code when the authenticator is lost
+	RecoveryCodes []string `json:"recovery_codes"`
+	// Tokens of a new session opened with two-factor authentication
+	LoginResponse
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// loginMFAHandler completes a login with the second factor
+// @Summary Complete login with two-factor authentication
+// @Description Exchange the mfa_token returned by /auth/login and a code of the authenticator app, or a recovery code, for tokens
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param mfaRequest body LoginMFARequest true "Second factor"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/login/mfa [post]
+func loginMFAHandler(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	user, err := parseMFAPendingToken(ctx, req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login, please log in again"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	// Codes are short, so guessing them is throttled like passwords
+	if !countLoginAttempt(c, ctx, db, user.Email) {
+		return
+	}
+
+	if err := verifySecondFactor(ctx, db, user, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, models.ErrInvalidMFACode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	forgiveLoginAttempt(ctx, db, user.Email, c.ClientIP())
+
+	response, err := issueTokens(c, user, primitive.NilObjectID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// mfaEnrollHandler starts the enrollment of an authenticator app
+// @Summary Start two-factor enrollment
+// @Description Generate a TOTP secret for the current user. It is enabled once confirmed with /auth/mfa/verify
+// @Tags Authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} MFAEnrollResponse
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/enroll [post]
+func mfaEnrollHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := helpers.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	err = database.GetDB().Collection("users").UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"mfa_pending_secret": secret}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: helpers.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// mfaVerifyHandler confirms the enrollment with a first code
+// @Summary Confirm two-factor enrollment
+// @Description Enable two-factor authentication with a code of the enrolled authenticator app. Returns the recovery codes and the tokens of a new session opened with two-factor authentication, replacing the current one
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param verifyRequest body MFACodeRequest true "Code of the authenticator app"
+// @Security ApiKeyAuth
+// @Success 200 {object} MFAVerifyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/verify [post]
+func mfaVerifyHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.MFAPendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start the enrollment with /auth/mfa/enroll first"})
+		return
+	}
+
+	step, valid := helpers.ValidateTOTP(user.MFAPendingSecret, req.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := models.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+	err = db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "mfa_pending_secret": user.MFAPendingSecret},
+		bson.M{
+			"$set": bson.M{
+				"mfa_enabled":        true,
+				"mfa_secret":         user.MFAPendingSecret,
+				"mfa_last_step":      step,
+				"mfa_recovery_codes": hashes,
+			},
+			"$unset": bson.M{"mfa_pending_secret": ""},
+		})
+	if err == qmgo.ErrNoSuchDocuments {
+		c.JSON(http.StatusConflict, gin.H{"error": "The enrollment changed, start again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	recordMFAEvent(ctx, db, user, models.SecurityEventMFAEnabled, models.UserActor(user))
+
+	// The current session was opened without the second factor
+	if sessionID, ok := c.Get("session_id"); ok {
+		if err := models.RevokeSession(ctx, db.Collection("refresh_tokens"), sessionID.(primitive.ObjectID)); err != nil {
+			log.Printf("Failed to revoke session of %s: %v", user.Email, err)
+		}
+	}
+
+	tokens, err := issueTokens(c, user, primitive.NilObjectID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAVerifyResponse{RecoveryCodes: codes, LoginResponse: tokens})
+}
+
+// mfaDisableHandler turns two-factor authentication off
+// @Summary Disable two-factor authentication
+// @Description Disable two-factor authentication with a code or a recovery code. Not allowed for users with admin roles while it is mandatory for them
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param disableRequest body MFADisableRequest true "Second factor"
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/disable [post]
+func mfaDisableHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req MFADisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if middleware.AdminMFARequired() && len(user.RoleNames()) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is mandatory for admin accounts"})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+	if err := verifySecondFactor(ctx, db, user, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, models.ErrInvalidMFACode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor code"})
+		return
+	}
+
+	if err := models.DisableMFA(ctx, db.Collection("users"), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	recordMFAEvent(ctx, db, user, models.SecurityEventMFADisabled, models.UserActor(user))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// mfaRecoveryCodesHandler replaces the recovery codes
+// @Summary Regenerate recovery codes
+// @Description Replace the recovery codes of the current user with new ones, given a code of the authenticator app
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param codeRequest body MFACodeRequest true "Code of the authenticator app"
+// @Security ApiKeyAuth
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/recovery-codes [post]
+func mfaRecoveryCodesHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	users := database.GetDB().Collection("users")
+	if err := models.VerifyTOTP(ctx, users, user, req.Code); err != nil {
+		if errors.Is(err, models.ErrInvalidMFACode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor code"})
+		return
+	}
+
+	codes, hashes, err := models.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	if err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"mfa_recovery_codes": hashes}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifySecondFactor checks a code of the authenticator app or, when code
+// is empty, a recovery code
+func verifySecondFactor(ctx context.Context, db *qmgo.Database, user models.User, code, recoveryCode string) error {
+	users := db.Collection("users")
+	if code != "" {
+		return models.VerifyTOTP(ctx, users, user, code)
+	}
+
+	if err := models.UseRecoveryCode(ctx, users, user, recoveryCode); err != nil {
+		return err
+	}
+	recordMFAEvent(ctx, db, user, models.SecurityEventRecoveryCodeUse, models.UserActor(user))
+	return nil
+}
+
+func recordMFAEvent(ctx context.Context, db *qmgo.Database, user models.User, eventType, actor string) {
+	err := models.RecordSecurityEvent(ctx, db, models.SecurityEvent{
+		Type:   eventType,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  actor,
+	})
+	if err != nil {
+		log.Printf("Failed to record %s of %s: %v", eventType, user.Email, err)
+	}
+}
+
+// signMFAPendingToken returns the token proving that the password of the
+// user was checked, to send along with the second factor
+func signMFAPendingToken(user models.User) (string, error) {
+	now := time.Now()
+	return helpers.JWTKeys().Sign(jwt.MapClaims{
+		"sub":     user.ID.Hex(),
+		"purpose": mfaPendingPurpose,
+		"ver":     user.TokenVersion,
+		"iat":     now.Unix(),
+		"exp":     now.Add(mfaPendingLifetime).Unix(),
+	})
+}
+
+// parseMFAPendingToken returns the user an mfa pending token was issued for
+func parseMFAPendingToken(ctx context.Context, tokenString string) (models.User, error) {
+	claims := jwt.MapClaims{}
+	if _, err := helpers.JWTKeys().Parse(tokenString, claims); err != nil {
+		return models.User{}, err
+	}
+	if claims["purpose"] != mfaPendingPurpose {
+		return models.User{}, errInvalidMFAToken
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err := primitive.ObjectIDFromHex(sub)
+	if err != nil {
+		return models.User{}, errInvalidMFAToken
+	}
+
+	var user models.User
+	if err := database.GetDB().Collection("users").Find(ctx, bson.M{"_id": userID}).One(&user); err != nil {
+		return models.User{}, err
+	}
+	version, _ := claims["ver"].(float64)
+	if int(version) != user.TokenVersion || !user.MFAEnabled {
+		return models.User{}, errInvalidMFAToken
+	}
+	return user, nil
+}
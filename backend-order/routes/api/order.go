@@ -22,9 +22,10 @@ func SetupOrderRoutes(r *gin.Engine) {
 	orderGroup := r.Group("/orders")
 	orderGroup.Use(middleware.AuthMiddleware())
 	{
-		orderGroup.GET("", getOrdersHandler)
-		orderGroup.POST("", createOrderHandler)
-		orderGroup.POST("/:id/cancel", cancelOrderHandler) // Add this line
+		orderGroup.GET("", middleware.RequireScope(models.PermissionOrdersRead), getOrdersHandler)
+		orderGroup.GET("/:id", middleware.RequireScope(models.PermissionOrdersRead), getOrderHandler)
+		orderGroup.POST("", middleware.RequireScope(models.PermissionOrdersWrite), middleware.RequireVerifiedEmail(), createOrderHandler)
+		orderGroup.POST("/:id/cancel", middleware.RequireScope(models.PermissionOrdersWrite), cancelOrderHandler)
 	}
 }
 
@@ -38,24 +39,17 @@ func SetupOrderRoutes(r *gin.Engine) {
 // @Failure 500 {object} map[string]string
 // @Router /orders [get]
 func getOrdersHandler(c *gin.Context) {
-	ctx := context.Background()
-	db := database.GetDB()
-	collection := db.Collection("orders")
-
-	// Get the authenticated user from the context
-	user, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-	authenticatedUser, ok := user.(models.User)
+	user, ok := middleware.CurrentUser(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user data"})
 		return
 	}
 
+	ctx := context.Background()
+	db := database.GetDB()
+	collection := db.Collection("orders")
+
 	var orders []models.Order
-	err := collection.Find(ctx, bson.M{"customer_id": authenticatedUser.Email}).Sort("-created_at").All(&orders)
+	err := collection.Find(ctx, orderOwnerFilter(user)).Sort("-created_at").All(&orders)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching orders"})
 		return
@@ -66,18 +60,93 @@ func getOrdersHandler(c *gin.Context) {
 		orders = []models.Order{}
 	}
 
+	// Present orders placed before line items existed in the same shape
+	for i := range orders {
+		orders[i].Items = orders[i].LineItems()
+	}
+
 	c.JSON(http.StatusOK, orders)
 }
 
-// CreateOrderRequest represents the request body for creating an order
+// @Summary Get my order
+// @Description Get a single order of the authenticated user
+// @Tags Orders
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.Order
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /orders/{id} [get]
+func getOrderHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	err = database.GetDB().Collection("orders").Find(c, ownedOrderFilter(orderID, user)).One(&order)
+	if err != nil {
+		// Orders of other users are reported as missing so their IDs can't be probed
+		if err == qmgo.ErrNoSuchDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching order"})
+		}
+		return
+	}
+	order.Items = order.LineItems()
+
+	c.JSON(http.StatusOK, order)
+}
+
+// orderOwnerFilter matches the orders of the user. Orders created before
+// they were linked to a user ID are matched by email until the
+// order_user_ids migration has backfilled them.
+func orderOwnerFilter(user models.User) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"user_id": user.ID},
+		{"user_id": bson.M{"$exists": false}, "customer_id": user.Email},
+	}}
+}
+
+// ownedOrderFilter matches a single order only if it belongs to the user
+func ownedOrderFilter(orderID primitive.ObjectID, user models.User) bson.M {
+	filter := orderOwnerFilter(user)
+	filter["_id"] = orderID
+	return filter
+}
+
+// OrderItemRequest represents one line of a new order
+type OrderItemRequest struct {
+	ProductID string `json:"product_id" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+}
+
+// CreateOrderRequest represents the request body for creating an order.
+// When Items is empty the order is placed for the content of the user's cart.
+// The customer is always the authenticated user.
 type CreateOrderRequest struct {
-	CustomerID string `json:"customer_id" binding:"required"`
-	ProductID  string `json:"product_id" binding:"required"`
-	Quantity   int    `json:"quantity" binding:"required,min=1"`
+	Items []OrderItemRequest `json:"items" binding:"omitempty,dive"`
 }
 
+var (
+	errProductNotFound    = errors.New("product not found")
+	errProductUnavailable = errors.New("product is not available")
+	errEmptyOrder         = errors.New("order has no items")
+	errPaymentInProgress  = errors.New("order is being paid")
+)
+
 // @Summary Create a new order
-// @Description Create a new order (requires authentication)
+// @Description Create a new order from the given items, or from the cart when no items are given (requires authentication)
 // @Tags Orders
 // @Security ApiKeyAuth
 // @Accept json
@@ -86,21 +155,26 @@ type CreateOrderRequest struct {
 // @Success 201 {object} models.Order
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /orders [post]
 func createOrderHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
 	var req CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Convert product_id string to ObjectID
-	productID, err := primitive.ObjectIDFromHex(req.ProductID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
-		return
+	fromCart := len(req.Items) == 0
+	lines := make([]models.CartItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		lines = append(lines, models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity})
 	}
 
 	var newOrder models.Order
@@ -111,34 +185,27 @@ func createOrderHandler(c *gin.Context) {
 			return nil, errors.New("database connection is nil")
 		}
 
-		// Fetch the product to ensure it exists and get its details
-		var product models.Product
-		err := db.Collection("products").Find(sessCtx, bson.M{"_id": productID}).One(&product)
-		if err != nil {
-			if err == qmgo.ErrNoSuchDocuments {
-				return nil, errors.New("product not found")
+		if fromCart {
+			cart, err := loadCart(sessCtx, user.ID)
+			if err != nil {
+				return nil, fmt.Errorf("error fetching cart: %v", err)
 			}
-			return nil, fmt.Errorf("error fetching product: %v", err)
+			lines = cart.Items
 		}
 
-		// Check if there's enough stock
-		if product.Stocks < req.Quantity {
-			return nil, errors.New("insufficient stock")
+		orderID := primitive.NewObjectID()
+		items, totalAmount, err := reserveOrderItems(sessCtx, orderID, models.UserActor(user), lines)
+		if err != nil {
+			return nil, err
 		}
 
-		// Calculate the total amount
-		totalAmount := float64(req.Quantity) * product.Price
-
 		newOrder = models.Order{
-			ID:         primitive.NewObjectID(),
-			CustomerID: req.CustomerID,
-			Product: models.OrderProduct{
-				ID:    product.ID.Hex(),
-				Name:  product.Name,
-				Price: product.Price,
-			},
-			Quantity:    req.Quantity,
+			ID:          orderID,
+			UserID:      user.ID,
+			CustomerID:  user.Email,
+			Items:       items,
 			TotalAmount: totalAmount,
+			Currency:    models.DefaultCurrency,
 			Status:      models.OrderStatusCreated,
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
@@ -146,6 +213,7 @@ func createOrderHandler(c *gin.Context) {
 				{
 					Name:      "Created",
 					Timestamp: time.Now(),
+					Actor:     models.UserActor(user),
 				},
 			},
 		}
@@ -156,25 +224,27 @@ func createOrderHandler(c *gin.Context) {
 			return nil, err
 		}
 
-		// Update the product stock
-		err = db.Collection("products").UpdateOne(sessCtx, bson.M{"_id": productID}, bson.M{
-			"$inc": bson.M{"stocks": -req.Quantity},
-		})
-		if err != nil {
-			return nil, err
+		// The cart has been turned into an order, empty it
+		if fromCart {
+			err = db.Collection("carts").UpdateOne(sessCtx, bson.M{"user_id": user.ID}, bson.M{
+				"$set": bson.M{"items": []models.CartItem{}, "updated_at": time.Now()},
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		return newOrder, nil
 	}
 
-	_, err = database.GetClient().DoTransaction(c, callback)
+	_, err := database.GetClient().DoTransaction(c, callback)
 
 	if err != nil {
 		switch {
-		case err.Error() == "product not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-		case err == qmgo.ErrTransactionNotSupported:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+		case errors.Is(err, errProductNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, errProductUnavailable), errors.Is(err, models.ErrInsufficientStock), errors.Is(err, errEmptyOrder):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
 			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing order: " + err.Error()})
@@ -185,80 +255,172 @@ func createOrderHandler(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "Order created successfully", "order": newOrder})
 }
 
+// reserveOrderItems prices the requested lines and decrements the stock of
+// every product. It must run inside a transaction so that a failure on one
+// line leaves the stock of the others untouched.
+func reserveOrderItems(sessCtx context.Context, orderID primitive.ObjectID, actor string, lines []models.CartItem) ([]models.OrderItem, float64, error) {
+	// Merge lines that refer to the same product, keeping the request order
+	quantities := make(map[string]int, len(lines))
+	productIDs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if _, seen := quantities[line.ProductID]; !seen {
+			productIDs = append(productIDs, line.ProductID)
+		}
+		quantities[line.ProductID] += line.Quantity
+	}
+	if len(productIDs) == 0 {
+		return nil, 0, errEmptyOrder
+	}
+
+	db := database.GetDB()
+	items := make([]models.OrderItem, 0, len(productIDs))
+	totalAmount := 0.0
+
+	for _, id := range productIDs {
+		quantity := quantities[id]
+
+		productID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s", errProductNotFound, id)
+		}
+
+		// Fetch the product to ensure it exists and get its details
+		var product models.Product
+		err = db.Collection("products").Find(sessCtx, bson.M{"_id": productID}).One(&product)
+		if err != nil {
+			if err == qmgo.ErrNoSuchDocuments {
+				return nil, 0, fmt.Errorf("%w: %s", errProductNotFound, id)
+			}
+			return nil, 0, fmt.Errorf("error fetching product: %v", err)
+		}
+
+		if !product.IsPurchasable() {
+			return nil, 0, fmt.Errorf("%w: %s", errProductUnavailable, product.Name)
+		}
+
+		// Check if there's enough stock
+		if product.Stocks < quantity {
+			return nil, 0, fmt.Errorf("%w for %s", models.ErrInsufficientStock, product.Name)
+		}
+
+		// Update the product stock and record the sale in the ledger
+		_, err = models.MoveStock(sessCtx, db, models.InventoryMovement{
+			ProductID: productID,
+			Type:      models.MovementTypeSale,
+			Quantity:  -quantity,
+			OrderID:   orderID.Hex(),
+			Actor:     actor,
+		})
+		if errors.Is(err, models.ErrInsufficientStock) {
+			return nil, 0, fmt.Errorf("%w for %s", models.ErrInsufficientStock, product.Name)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		subtotal := float64(quantity) * product.Price
+		items = append(items, models.OrderItem{
+			Product: models.OrderProduct{
+				ID:    product.ID.Hex(),
+				Name:  product.Name,
+				Price: product.Price,
+			},
+			Quantity: quantity,
+			Subtotal: subtotal,
+		})
+		totalAmount += subtotal
+	}
+
+	return items, totalAmount, nil
+}
+
 // @Summary Cancel an order
-// @Description Cancel an existing order (requires authentication)
+// @Description Cancel an existing order and restore its stock (requires authentication). Cancelling an already cancelled order returns it unchanged, cancelling an order while it is being paid returns 409.
 // @Tags Orders
 // @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path string true "Order ID"
-// @Success 200 {object} map[string]string
+// @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /orders/{id}/cancel [post]
 func cancelOrderHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
 	orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
 		return
 	}
 
-	ctx := context.Background()
-	db := database.GetDB()
-
 	var order models.Order
-	err = db.Collection("orders").Find(ctx, bson.M{"_id": orderID}).One(&order)
-	if err != nil {
-		if err == qmgo.ErrNoSuchDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching order"})
+	alreadyCancelled := false
+
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		db := database.GetDB()
+
+		err := db.Collection("orders").Find(sessCtx, ownedOrderFilter(orderID, user)).One(&order)
+		if err != nil {
+			return nil, err
 		}
-		return
-	}
 
-	if order.Status != models.OrderStatusCreated {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Order cannot be cancelled"})
-		return
-	}
+		// A repeated request must not restore the stock a second time
+		if order.Status == models.OrderStatusCancelled {
+			alreadyCancelled = true
+			return order, nil
+		}
+		alreadyCancelled = false
 
-	now := time.Now()
-	update := bson.M{
-		"$set": bson.M{
-			"status":     models.OrderStatusCancelled,
-			"updated_at": now,
-		},
-		"$push": bson.M{
-			"timeline": models.TimelineEvent{
-				Name:      "Cancelled",
-				Timestamp: now,
-			},
-		},
-	}
+		// The customer would be charged for a cancelled order
+		if order.Status == models.OrderStatusPaymentPending {
+			return nil, errPaymentInProgress
+		}
 
-	err = db.Collection("orders").UpdateOne(ctx, bson.M{"_id": orderID}, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error cancelling order"})
-		return
+		err = models.TransitionOrder(sessCtx, db.Collection("orders"), &order, models.OrderTransition{
+			To:     models.OrderStatusCancelled,
+			Actor:  models.UserActor(user),
+			Reason: "Cancelled by customer",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := order.RestoreStock(sessCtx, db, models.UserActor(user), "Cancelled by customer"); err != nil {
+			return nil, err
+		}
+
+		return order, nil
 	}
 
-	// Convert the product ID string to ObjectID
-	productID, err := primitive.ObjectIDFromHex(order.Product.ID)
+	_, err = database.GetClient().DoTransaction(c, callback)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid product ID in order"})
+		switch {
+		case err == qmgo.ErrNoSuchDocuments:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		case errors.Is(err, models.ErrInvalidOrderTransition):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Order cannot be cancelled"})
+		case errors.Is(err, errPaymentInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "Order is being paid and can't be cancelled"})
+		case errors.Is(err, models.ErrOrderStatusConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "Order was updated concurrently, please retry"})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error cancelling order"})
+		}
 		return
 	}
 
-	// Restore the product stock
-	err = db.Collection("products").UpdateOne(ctx, bson.M{"_id": productID}, bson.M{
-		"$inc": bson.M{"stocks": order.Quantity},
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring product stock"})
+	order.Items = order.LineItems()
+	if alreadyCancelled {
+		c.JSON(http.StatusOK, gin.H{"message": "Order already cancelled", "order": order})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully", "order": order})
 }
@@ -3,12 +3,13 @@ package api
 import (
 	"context"
 	"net/http"
+	"strconv"
 
 	"backend-order/database"
+	"backend-order/helpers"
 	"backend-order/models"
 
 	"github.com/gin-gonic/gin"
-	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -19,38 +20,113 @@ func SetupProductRoutes(r *gin.Engine) {
 	r.GET("/products/:id", GetProduct)
 }
 
+// productSortFields maps the sort keys accepted by /products to document fields
+var productSortFields = map[string]string{
+	"name":   "name",
+	"price":  "price",
+	"stocks": "stocks",
+}
+
 // @Summary Get products
-// @Description Get the list of all products
+// @Description Search the products available for purchase, with cursor pagination
 // @Tags Products
 // @Produce json
-// @Success 200 {array} models.Product
+// @Param q query string false "Text search on name and description"
+// @Param min_price query number false "Minimum price"
+// @Param max_price query number false "Maximum price"
+// @Param in_stock query bool false "Only products with stock left"
+// @Param sort query string false "Comma separated sort keys among name, price, stocks; prefix with - for descending" default(name)
+// @Param limit query int false "Page size, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} helpers.Page[models.Product]
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /products [get]
 func getProductsHandler(c *gin.Context) {
-	var products []models.Product
+	filter := models.PurchasableProductFilter()
+
+	if q := c.Query("q"); q != "" {
+		filter["$text"] = bson.M{"$search": q}
+	}
+
+	price := bson.M{}
+	for param, op := range map[string]string{"min_price": "$gte", "max_price": "$lte"} {
+		if value := c.Query(param); value != "" {
+			amount, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			price[op] = amount
+		}
+	}
+	if len(price) > 0 {
+		filter["price"] = price
+	}
+
+	if c.Query("in_stock") == "true" {
+		filter["stocks"] = bson.M{"$gt": 0}
+	}
+
+	keys, err := helpers.ParseSort(c.Query("sort"), productSortFields, "name")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := helpers.ParseLimit(c.Query("limit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx := context.Background()
+	collection := database.GetDB().Collection("products")
 
-	db := database.GetDB()
-	err := db.Collection("products").Find(ctx, bson.M{}).All(&products)
+	total, err := collection.Find(ctx, filter).Count()
 	if err != nil {
-		// If the error is due to no documents found, return an empty array
-		if err == qmgo.ErrNoSuchDocuments {
-			c.JSON(http.StatusOK, []models.Product{})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error counting products"})
+		return
+	}
+
+	pageFilter := filter
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := helpers.CursorFilter(keys, cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		pageFilter = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	// Fetch one extra product to know whether there is a next page
+	var products []models.Product
+	err = collection.Find(ctx, pageFilter).Sort(helpers.SortFields(keys)...).Limit(limit + 1).All(&products)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
 		return
 	}
 
+	page := helpers.Page[models.Product]{Items: products, Total: total}
+	if int64(len(products)) > limit {
+		page.Items = products[:limit]
+		page.NextCursor, err = helpers.EncodeCursor(keys, page.Items[limit-1])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error paginating products"})
+			return
+		}
+	}
+
 	// If products is nil, return an empty array instead
-	if products == nil {
-		products = []models.Product{}
+	if page.Items == nil {
+		page.Items = []models.Product{}
 	}
 
-	c.JSON(http.StatusOK, products)
+	c.JSON(http.StatusOK, page)
 }
 
 // @Summary Get product by ID
-// @Description Get detailed information of a specific product
+// @Description Get detailed information of a specific product, including archived products referenced by orders
 // @Tags Products
 // @Produce json
 // @Param id path string true "Product ID"
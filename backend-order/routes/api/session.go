@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"backend-order/database"
+	"backend-order/helpers"
+	"backend-order/middleware"
+	"backend-order/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// refreshHandler exchanges a refresh token for a new pair of tokens
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token can be used once; using it again revokes the session
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param refreshRequest body RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/refresh [post]
+func refreshHandler(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	db := database.GetDB()
+
+	record, err := models.ConsumeRefreshToken(ctx, db.Collection("refresh_tokens"), req.RefreshToken)
+	switch err {
+	case nil:
+	case models.ErrRefreshTokenReused:
+		log.Println("Refresh token reused, its session was revoked")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	case models.ErrInvalidRefreshToken:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	var user models.User
+	if err := db.Collection("users").Find(ctx, bson.M{"_id": record.UserID}).One(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	response, err := issueTokens(c, user, record.SessionID, record.MFA)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// logoutHandler ends the session of a refresh token
+// @Summary Logout
+// @Description Revoke the session of the refresh token. Access tokens of the session stop working as well
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param logoutRequest body RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/logout [post]
+func logoutHandler(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	tokens := database.GetDB().Collection("refresh_tokens")
+
+	// Unknown tokens are ignored so that logging out twice succeeds
+	var record models.RefreshToken
+	err := tokens.Find(ctx, bson.M{"token_hash": models.HashToken(req.RefreshToken)}).One(&record)
+	if err == nil {
+		if err := models.RevokeSession(ctx, tokens, record.SessionID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// logoutAllHandler ends every session of the current user
+// @Summary Logout everywhere
+// @Description Revoke every session, access token and API key of the current user
+// @Tags Authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/logout-all [post]
+func logoutAllHandler(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := models.RevokeUserAccess(context.Background(), database.GetDB(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all sessions"})
+}
+
+// issueTokens returns an access token and a refresh token for the session,
+// starting a new session when sessionID is nil. mfa tells whether the
+// session was opened with a second factor.
+func issueTokens(c *gin.Context, user models.User, sessionID primitive.ObjectID, mfa bool) (LoginResponse, error) {
+	refreshToken, record, err := models.IssueRefreshToken(context.Background(), database.GetDB().Collection("refresh_tokens"),
+		user.ID, sessionID, mfa, c.Request.UserAgent(), durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL))
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	ttl := durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+	now := time.Now()
+	token, err := helpers.JWTKeys().Sign(jwt.MapClaims{
+		"id":      user.ID.Hex(),
+		"email":   user.Email,
+		"isAdmin": user.IsAdmin,
+		"roles":   user.RoleNames(),
+		"sid":     record.SessionID.Hex(),
+		"ver":     user.TokenVersion,
+		"mfa":     mfa,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{Token: token, RefreshToken: refreshToken, ExpiresIn: int64(ttl.Seconds())}, nil
+}
+
+// durationFromEnv reads a duration such as "15m" or "720h" from the environment
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %s", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
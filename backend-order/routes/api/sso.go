@@ -0,0 +1,317 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"backend-order/database"
+	"backend-order/helpers"
+	"backend-order/middleware"
+	"backend-order/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OIDCAuthorizeResponse struct {
+	// AuthorizationURL is the identity provider page to redirect the browser to
+	AuthorizationURL string `json:"authorization_url"`
+	// State comes back with the code, the frontend should check it matches
+	State string `json:"state"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+var (
+	ssoRoleMapping     models.GroupRoleMapping
+	ssoRoleMappingOnce sync.Once
+)
+
+// ssoRoles returns the mapping of identity provider groups to roles read from
+// OIDC_GROUP_ROLES, such as "shop-admins=admin,shop-support=support"
+func ssoRoles() models.GroupRoleMapping {
+	ssoRoleMappingOnce.Do(func() {
+		var err error
+		ssoRoleMapping, err = models.ParseGroupRoleMapping(os.Getenv("OIDC_GROUP_ROLES"))
+		if err != nil {
+			log.Fatalf("Invalid OIDC_GROUP_ROLES: %v", err)
+		}
+	})
+	return ssoRoleMapping
+}
+
+// ssoGroupsClaim is the ID token claim listing the groups of the user
+func ssoGroupsClaim() string {
+	if claim := os.Getenv("OIDC_GROUPS_CLAIM"); claim != "" {
+		return claim
+	}
+	return "groups"
+}
+
+// oidcAuthorizeHandler starts a single sign-on login
+// @Summary Start single sign-on
+// @Description Start a login with the OpenID Connect identity provider, using the authorization code flow with PKCE. Redirect the browser to the returned URL; the provider sends it back to the frontend with a code and the state, to post to /auth/oidc/callback within 10 minutes
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} OIDCAuthorizeResponse
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /auth/oidc/authorize [post]
+func oidcAuthorizeHandler(c *gin.Context) {
+	provider := helpers.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	state, err := helpers.RandomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return
+	}
+	nonce, err := helpers.RandomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return
+	}
+	verifier, challenge, err := helpers.NewPKCEVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	authorizationURL, err := provider.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		log.Printf("Failed to reach the identity provider: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	if err := models.StartOIDCLogin(ctx, database.GetDB().Collection("oidc_logins"), state, verifier, nonce); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return
+	}
+
+	c.JSON(http.StatusOK, OIDCAuthorizeResponse{AuthorizationURL: authorizationURL, State: state})
+}
+
+// oidcCallbackHandler completes a single sign-on login
+// @Summary Complete single sign-on
+// @Description Exchange the code sent back by the identity provider for tokens. The user is found by their provider account, linked by verified email, or created. Roles mapped from provider groups with OIDC_GROUP_ROLES are updated at each login. Users with two-factor authentication get an MFAChallengeResponse unless the provider did it
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param callbackRequest body OIDCCallbackRequest true "Code and state sent back by the provider"
+// @Success 200 {object} LoginResponse
+// @Success 200 {object} MFAChallengeResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/oidc/callback [post]
+func oidcCallbackHandler(c *gin.Context) {
+	provider := helpers.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+
+	login, err := models.ConsumeOIDCLogin(ctx, db.Collection("oidc_logins"), req.State)
+	if err == models.ErrInvalidOIDCState {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Single sign-on expired, please try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	claims, err := provider.Exchange(ctx, req.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	user, status, err := ssoUser(ctx, db, provider.Issuer, claims)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Printf("Single sign-on of %s failed: %v", claims.Subject, err)
+			c.JSON(status, gin.H{"error": "Single sign-on failed"})
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	// The provider did the second factor if it says so, or if it is trusted
+	// to enforce one for every login
+	mfa := os.Getenv("OIDC_TRUST_MFA") == "true"
+	for _, method := range claims.AMR {
+		if method == "mfa" {
+			mfa = true
+		}
+	}
+
+	if user.MFAEnabled && !mfa {
+		mfaToken, err := signMFAPendingToken(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	response, err := issueTokens(c, user, primitive.NilObjectID, mfa)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+	response.MFAEnrollmentRequired = !mfa && middleware.AdminMFARequired() && len(user.RoleNames()) > 0
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ssoError is an error shown to the user with its status
+type ssoError string
+
+func (e ssoError) Error() string { return string(e) }
+
+// ssoUser finds, links or creates the user of the provider account, and
+// updates the roles mapped from its groups. The status tells how to report
+// an error.
+func ssoUser(ctx context.Context, db *qmgo.Database, issuer string, claims helpers.OIDCClaims) (models.User, int, error) {
+	users := db.Collection("users")
+
+	var user models.User
+	err := users.Find(ctx, bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject}).One(&user)
+	if err != nil && err != qmgo.ErrNoSuchDocuments {
+		return models.User{}, http.StatusInternalServerError, err
+	}
+
+	if err == qmgo.ErrNoSuchDocuments {
+		if claims.Email == "" {
+			return models.User{}, http.StatusBadRequest, ssoError("The identity provider didn't share your email address")
+		}
+
+		err = users.Find(ctx, bson.M{"email": claims.Email}).One(&user)
+		switch {
+		case err == nil:
+			// Linking on an unverified email would let anyone claim an account
+			if !claims.EmailVerified {
+				return models.User{}, http.StatusConflict, ssoError("An account already exists for this email, log in with your password")
+			}
+			if user.OIDCSubject != "" {
+				return models.User{}, http.StatusConflict, ssoError("This account is linked to another identity")
+			}
+			recordSSOEvent(ctx, db, user, models.SecurityEventSSOLinked)
+		case err == qmgo.ErrNoSuchDocuments:
+			if os.Getenv("OIDC_AUTO_PROVISION") == "false" {
+				return models.User{}, http.StatusUnauthorized, ssoError("No account exists for this email")
+			}
+			// The account would belong to whoever owns the address later
+			if !claims.EmailVerified {
+				return models.User{}, http.StatusForbidden, ssoError("Verify your email address with the identity provider first")
+			}
+			// Without a password, only single sign-on can log in
+			user = models.User{ID: primitive.NewObjectID(), Email: claims.Email}
+			if _, err := users.InsertOne(ctx, user); err != nil {
+				if qmgo.IsDup(err) {
+					return models.User{}, http.StatusConflict, ssoError("An account already exists for this email")
+				}
+				return models.User{}, http.StatusInternalServerError, err
+			}
+		default:
+			return models.User{}, http.StatusInternalServerError, err
+		}
+	}
+
+	update := bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject}
+	if claims.EmailVerified && claims.Email == user.Email {
+		update["email_verified"] = true
+	}
+	demoted := false
+	if mapping := ssoRoles(); len(mapping) > 0 {
+		roles := mapping.SyncRoles(user.RoleNames(), helpers.ClaimStrings(claims.Raw, ssoGroupsClaim()))
+		// isAdmin is kept in sync with the admin role for clients reading the flag
+		update["roles"] = roles
+		update["isAdmin"] = models.User{Roles: roles}.HasRole(models.RoleAdmin)
+		demoted = user.IsActiveAdmin() && !update["isAdmin"].(bool)
+	}
+
+	if !demoted {
+		err = users.Find(ctx, bson.M{"_id": user.ID}).Apply(qmgo.Change{
+			Update:    bson.M{"$set": update},
+			ReturnNew: true,
+		}, &user)
+		if err != nil {
+			return models.User{}, http.StatusInternalServerError, err
+		}
+		return user, http.StatusOK, nil
+	}
+
+	// The groups of the last active admin must not lock everyone out
+	callback := func(sessCtx context.Context) (interface{}, error) {
+		set := bson.M{}
+		for k, v := range update {
+			set[k] = v
+		}
+		err := models.EnsureAnotherActiveAdmin(sessCtx, db, user.ID)
+		if err == models.ErrLastAdmin {
+			log.Printf("Keeping the admin role of %s, the last active admin", user.Email)
+			set["roles"] = append(update["roles"].([]string), models.RoleAdmin)
+			set["isAdmin"] = true
+		} else if err != nil {
+			return nil, err
+		}
+
+		var saved models.User
+		err = users.Find(sessCtx, bson.M{"_id": user.ID}).Apply(qmgo.Change{
+			Update:    bson.M{"$set": set},
+			ReturnNew: true,
+		}, &saved)
+		return saved, err
+	}
+	saved, err := database.GetClient().DoTransaction(ctx, callback)
+	if err != nil {
+		return models.User{}, http.StatusInternalServerError, err
+	}
+	return saved.(models.User), http.StatusOK, nil
+}
+
+func recordSSOEvent(ctx context.Context, db *qmgo.Database, user models.User, eventType string) {
+	err := models.RecordSecurityEvent(ctx, db, models.SecurityEvent{
+		Type:   eventType,
+		UserID: &user.ID,
+		Email:  user.Email,
+		Actor:  models.UserActor(user),
+	})
+	if err != nil {
+		log.Printf("Failed to record %s of %s: %v", eventType, user.Email, err)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"backend-order/database"
+	"backend-order/helpers"
+	"backend-order/models"
+	"backend-order/vendors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	emailVerificationPurpose  = "verify_email"
+	emailVerificationLifetime = 24 * time.Hour
+)
+
+var errInvalidVerificationToken = errors.New("invalid verification token")
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// verifyEmailHandler marks the email of a user as verified
+// @Summary Verify email
+// @Description Verify the email address of an account with the token sent by email
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param verifyRequest body VerifyEmailRequest true "Verification token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/verify-email [post]
+func verifyEmailHandler(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, email, err := parseEmailVerificationToken(req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	// The token only verifies the address it was sent to
+	err = database.GetDB().Collection("users").UpdateOne(context.Background(),
+		bson.M{"_id": userID, "email": email},
+		bson.M{"$set": bson.M{"email_verified": true}})
+	if err == qmgo.ErrNoSuchDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// resendVerificationHandler sends a new verification email
+// @Summary Resend verification email
+// @Description Send a new verification email to an unverified account. The response is the same, and as fast, whether or not the account exists. Requests are limited per email and per client IP, with 429 and a Retry-After header beyond
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param resendRequest body ResendVerificationRequest true "User's email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/resend-verification [post]
+func resendVerificationHandler(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Requests are counted whether or not the account exists
+	if !countVerificationRequest(c, database.GetDB().Collection("login_throttles"), req.Email) {
+		return
+	}
+
+	// The answer is the same, and as fast, whether or not the account exists,
+	// so that it can't be used to find out which emails are registered
+	go func(email string) {
+		var user models.User
+		err := database.GetDB().Collection("users").Find(context.Background(), bson.M{"email": email}).One(&user)
+		if err != nil || user.EmailVerified {
+			return
+		}
+		if err := sendVerificationEmail(user); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+		}
+	}(req.Email)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an unverified account exists for this email, a verification email has been sent.",
+	})
+}
+
+// countVerificationRequest counts a verification email request of the email
+// and of the client IP with the login throttle. It writes 429 and returns
+// false once either asked for too many.
+func countVerificationRequest(c *gin.Context, throttles *qmgo.Collection, email string) bool {
+	counts := []struct {
+		key    string
+		policy models.LoginThrottlePolicy
+	}{
+		{models.VerificationThrottleKey(email), models.VerificationAccountPolicy},
+		{models.VerificationIPThrottleKey(c.ClientIP()), models.VerificationIPPolicy},
+	}
+	for _, count := range counts {
+		wait, _, err := models.CountLoginAttempt(c, throttles, count.key, count.policy)
+		if err != nil {
+			log.Printf("Failed to count verification request %s: %v", count.key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return false
+		}
+		if wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails requested, try again later"})
+			return false
+		}
+	}
+	return true
+}
+
+// sendVerificationEmail emails a verification link to the user
+func sendVerificationEmail(user models.User) error {
+	now := time.Now()
+	token, err := helpers.JWTKeys().Sign(jwt.MapClaims{
+		"sub":     user.ID.Hex(),
+		"email":   user.Email,
+		"purpose": emailVerificationPurpose,
+		"iat":     now.Unix(),
+		"exp":     now.Add(emailVerificationLifetime).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := helpers.FrontendLink("/verify-email", url.Values{"token": {token}})
+	return vendors.SendEmail(vendors.EmailData{
+		To:      []vendors.EmailAddress{{Email: user.Email, Name: user.Email}},
+		Subject: "Verify your email address",
+		Text:    fmt.Sprintf("Dear %s,\n\nWelcome to our service! Please confirm your email address by opening this link:\n\n%s\n\nThe link expires in 24 hours.\n\nBest regards,\nThe Team", user.Email, link),
+	})
+}
+
+// parseEmailVerificationToken returns the user and email a verification token was issued for
+func parseEmailVerificationToken(tokenString string) (primitive.ObjectID, string, error) {
+	claims := jwt.MapClaims{}
+	if _, err := helpers.JWTKeys().Parse(tokenString, claims); err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	if claims["purpose"] != emailVerificationPurpose {
+		return primitive.NilObjectID, "", errInvalidVerificationToken
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err := primitive.ObjectIDFromHex(sub)
+	if err != nil {
+		return primitive.NilObjectID, "", errInvalidVerificationToken
+	}
+	email, _ := claims["email"].(string)
+	return userID, email, nil
+}
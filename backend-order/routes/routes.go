@@ -4,6 +4,7 @@ import (
 	"backend-order/routes/api"
 	"backend-order/routes/api/admin"
 	"backend-order/routes/api/backend"
+	"log"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -25,11 +26,20 @@ func SetupRoutes(r *gin.Engine) {
 	r.GET("/health", healthCheckHandler)
 	api.SetupAuthRoutes(r)
 	api.SetupProductRoutes(r)
+	api.SetupCartRoutes(r)
 	api.SetupOrderRoutes(r)
+	api.SetupAPIKeyRoutes(r)
 
 	admin.SetupAdminProductRoutes(r)
+	admin.SetupAdminInventoryRoutes(r)
 	admin.SetupAdminOrderRoutes(r)
 	admin.SetupAdminUserRoutes(r)
+	admin.SetupAdminRoleRoutes(r)
+
+	// Refuse to start if an admin route bypassed the admin guard
+	if err := admin.VerifyRoutes(r); err != nil {
+		log.Fatal(err)
+	}
 
 	// Add this line to set up the new backend payment routes
 	backend.SetupBackendPaymentRoutes(r)
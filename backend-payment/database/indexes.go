@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the API relies on. Creating an index that
+// already exists with the same definition is a no-op.
+func EnsureIndexes(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		"transactions": {
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "order_notified", Value: 1}, {Key: "status", Value: 1}}},
+			// At most one pending or completed transaction per order
+			{Keys: bson.D{{Key: "active_order_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		},
+		"refunds": {
+			{Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "order_notified", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+		"idempotency_keys": {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+
+	for name, models := range indexes {
+		collection, err := GetDB().Collection(name).CloneCollection()
+		if err != nil {
+			return err
+		}
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package gateway abstracts the payment providers backend-payment charges
+// through. Providers are picked by name from the registry.
+package gateway
+
+import (
+	"context"
+	"errors"
+)
+
+// Statuses of a payment at the provider
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusPending    = "pending"
+	StatusDeclined   = "declined"
+	StatusVoided     = "voided"
+	StatusRefunded   = "refunded"
+)
+
+var (
+	// ErrTimeout means the provider didn't answer in time. The outcome is
+	// unknown, so the payment must be checked with Status before retrying.
+	ErrTimeout = errors.New("payment gateway timeout")
+	// ErrUnknownPayment is returned for references the provider doesn't know
+	ErrUnknownPayment = errors.New("unknown payment reference")
+)
+
+// AuthorizeRequest describes the payment to authorize
+type AuthorizeRequest struct {
+	OrderID  string
+	Amount   float64
+	Currency string
+	// CardToken identifies the payment method tokenized by the provider
+	CardToken string
+	// IdempotencyKey identifies the attempt, so that a retried authorization
+	// isn't charged twice and a lost answer can be found with Lookup
+	IdempotencyKey string
+}
+
+// Result is the state of a payment after an operation
+type Result struct {
+	// Reference identifies the payment at the provider
+	Reference string
+	Status    string
+	// Message explains declines and other failures
+	Message string
+}
+
+// PaymentGateway is a payment provider. Authorize reserves the amount,
+// Capture charges an authorized payment, Void releases an authorization that
+// wasn't captured and Refund returns part or all of a captured amount. A
+// refund sent again with the same idempotency key returns the refund already
+// made instead of refunding twice, so refunds that timed out can be retried.
+// Lookup finds a payment by the idempotency key of its authorization, when
+// its answer was lost; it returns ErrUnknownPayment if the provider never
+// received it.
+type PaymentGateway interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (Result, error)
+	Capture(ctx context.Context, reference string, amount float64) (Result, error)
+	Void(ctx context.Context, reference string) (Result, error)
+	Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) (Result, error)
+	Status(ctx context.Context, reference string) (Result, error)
+	Lookup(ctx context.Context, idempotencyKey string) (Result, error)
+}
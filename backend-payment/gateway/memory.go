@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-payment/helpers"
+)
+
+// memoryPayment is a payment held by an in-memory provider
+type memoryPayment struct {
+	status   string
+	amount   float64
+	captured float64
+	refunded float64
+}
+
+// memoryStore keeps the payments of the simulated providers. They are lost
+// on restart, like the state of a sandbox that is reset.
+type memoryStore struct {
+	prefix   string
+	mu       sync.Mutex
+	payments map[string]*memoryPayment
+	// byKey maps idempotency keys to references
+	byKey map[string]string
+	// refunds holds the refunds made, by idempotency key
+	refunds map[string]Result
+}
+
+func newMemoryStore(prefix string) *memoryStore {
+	return &memoryStore{
+		prefix:   prefix,
+		payments: map[string]*memoryPayment{},
+		byKey:    map[string]string{},
+		refunds:  map[string]Result{},
+	}
+}
+
+// create records a payment, or returns the payment already recorded for the
+// idempotency key
+func (s *memoryStore) create(key, status string, amount float64) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reference, ok := s.byKey[key]; ok && key != "" {
+		return Result{Reference: reference, Status: s.payments[reference].status}
+	}
+	reference := s.prefix + primitive.NewObjectID().Hex()
+	s.payments[reference] = &memoryPayment{status: status, amount: amount}
+	if key != "" {
+		s.byKey[key] = reference
+	}
+	return Result{Reference: reference, Status: status}
+}
+
+func (s *memoryStore) lookup(key string) (Result, error) {
+	s.mu.Lock()
+	reference, ok := s.byKey[key]
+	s.mu.Unlock()
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	return s.status(reference)
+}
+
+func (s *memoryStore) status(reference string) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (s *memoryStore) capture(reference string, amount float64) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	if payment.status != StatusAuthorized {
+		return Result{}, fmt.Errorf("can't capture a %s payment", payment.status)
+	}
+	if amount > payment.amount {
+		return Result{}, fmt.Errorf("capture of %.2f exceeds the authorized %.2f", amount, payment.amount)
+	}
+	payment.status = StatusCaptured
+	payment.captured = amount
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (s *memoryStore) void(reference string) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	if payment.status != StatusAuthorized && payment.status != StatusPending {
+		return Result{}, fmt.Errorf("can't void a %s payment", payment.status)
+	}
+	payment.status = StatusVoided
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (s *memoryStore) refund(reference string, amount float64, key string) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if result, ok := s.refunds[key]; ok && key != "" {
+		return result, nil
+	}
+	payment, ok := s.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	if payment.status != StatusCaptured && payment.status != StatusRefunded {
+		return Result{}, fmt.Errorf("can't refund a %s payment", payment.status)
+	}
+	// Compare in cents so that float rounding doesn't refuse a full refund
+	if helpers.ToCents(payment.refunded+amount) > helpers.ToCents(payment.captured) {
+		return Result{}, fmt.Errorf("refund of %.2f exceeds the %.2f left", amount, payment.captured-payment.refunded)
+	}
+	payment.refunded += amount
+	if helpers.ToCents(payment.refunded) == helpers.ToCents(payment.captured) {
+		payment.status = StatusRefunded
+	}
+	result := Result{Reference: reference, Status: StatusRefunded}
+	if key != "" {
+		s.refunds[key] = result
+	}
+	return result, nil
+}
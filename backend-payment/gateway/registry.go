@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Factory creates a provider
+type Factory func() (PaymentGateway, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		"simulator": func() (PaymentGateway, error) { return NewSimulator(), nil },
+		"test":      func() (PaymentGateway, error) { return NewTestGateway(), nil },
+	}
+
+	instancesMu sync.Mutex
+	instances   = map[string]PaymentGateway{}
+
+	defaultGateway     PaymentGateway
+	defaultGatewayOnce sync.Once
+)
+
+// Register makes a provider available under the name
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// New creates the provider registered under the name
+func New(name string) (PaymentGateway, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown payment gateway %q, available: %v", name, Names())
+	}
+	return factory()
+}
+
+// Get returns the shared instance of the provider registered under the name,
+// so that a payment is refunded or checked by the provider that charged it
+func Get(name string) (PaymentGateway, error) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	if gateway, ok := instances[name]; ok {
+		return gateway, nil
+	}
+	gateway, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+	instances[name] = gateway
+	return gateway, nil
+}
+
+// Names lists the registered providers
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Default returns the provider named by PAYMENT_GATEWAY, the simulator when
+// it is not set
+func Default() PaymentGateway {
+	defaultGatewayOnce.Do(func() {
+		name := os.Getenv("PAYMENT_GATEWAY")
+		if name == "" {
+			name = "simulator"
+		}
+		var err error
+		defaultGateway, err = Get(name)
+		if err != nil {
+			log.Fatalf("Failed to set up the payment gateway: %v", err)
+		}
+		log.Printf("Using the %s payment gateway", defaultGateway.Name())
+	})
+	return defaultGateway
+}
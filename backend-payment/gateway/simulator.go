@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"context"
+	"math/rand/v2"
+)
+
+// Simulator approves 80% of the payments at random, to exercise both
+// outcomes without a real provider
+type Simulator struct {
+	store *memoryStore
+}
+
+func NewSimulator() *Simulator {
+	return &Simulator{store: newMemoryStore("sim_")}
+}
+
+func (s *Simulator) Name() string { return "simulator" }
+
+func (s *Simulator) Authorize(ctx context.Context, req AuthorizeRequest) (Result, error) {
+	if rand.Float32() >= 0.8 {
+		result := s.store.create(req.IdempotencyKey, StatusDeclined, req.Amount)
+		result.Message = "Payment declined"
+		return result, nil
+	}
+	return s.store.create(req.IdempotencyKey, StatusAuthorized, req.Amount), nil
+}
+
+func (s *Simulator) Capture(ctx context.Context, reference string, amount float64) (Result, error) {
+	return s.store.capture(reference, amount)
+}
+
+func (s *Simulator) Void(ctx context.Context, reference string) (Result, error) {
+	return s.store.void(reference)
+}
+
+func (s *Simulator) Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) (Result, error) {
+	return s.store.refund(reference, amount, idempotencyKey)
+}
+
+func (s *Simulator) Status(ctx context.Context, reference string) (Result, error) {
+	return s.store.status(reference)
+}
+
+func (s *Simulator) Lookup(ctx context.Context, idempotencyKey string) (Result, error) {
+	return s.store.lookup(idempotencyKey)
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+
+	"backend-payment/helpers"
+)
+
+// Card tokens forcing an outcome with the test provider
+const (
+	TestTokenSuccess = "tok_success"
+	TestTokenDecline = "tok_decline"
+	TestTokenTimeout = "tok_timeout"
+	TestTokenPending = "tok_pending"
+)
+
+// TestGateway is a deterministic provider for automated tests. The outcome
+// of an authorization is forced by the card token, or when no test token is
+// given, by the cents of the amount:
+//
+//	.01 declined
+//	.02 timeout
+//	.03 pending
+//
+// Any other payment is authorized. Timed out authorizations never reach the
+// provider, so Lookup doesn't find them.
+type TestGateway struct {
+	store *memoryStore
+}
+
+func NewTestGateway() *TestGateway {
+	return &TestGateway{store: newMemoryStore("test_")}
+}
+
+func (g *TestGateway) Name() string { return "test" }
+
+func (g *TestGateway) Authorize(ctx context.Context, req AuthorizeRequest) (Result, error) {
+	switch testOutcome(req) {
+	case TestTokenDecline:
+		result := g.store.create(req.IdempotencyKey, StatusDeclined, req.Amount)
+		result.Message = "Card declined"
+		return result, nil
+	case TestTokenTimeout:
+		return Result{}, ErrTimeout
+	case TestTokenPending:
+		return g.store.create(req.IdempotencyKey, StatusPending, req.Amount), nil
+	}
+	return g.store.create(req.IdempotencyKey, StatusAuthorized, req.Amount), nil
+}
+
+func (g *TestGateway) Capture(ctx context.Context, reference string, amount float64) (Result, error) {
+	return g.store.capture(reference, amount)
+}
+
+func (g *TestGateway) Void(ctx context.Context, reference string) (Result, error) {
+	return g.store.void(reference)
+}
+
+func (g *TestGateway) Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) (Result, error) {
+	return g.store.refund(reference, amount, idempotencyKey)
+}
+
+func (g *TestGateway) Status(ctx context.Context, reference string) (Result, error) {
+	return g.store.status(reference)
+}
+
+func (g *TestGateway) Lookup(ctx context.Context, idempotencyKey string) (Result, error) {
+	return g.store.lookup(idempotencyKey)
+}
+
+// testOutcome returns the test token matching the outcome of the request
+func testOutcome(req AuthorizeRequest) string {
+	switch req.CardToken {
+	case TestTokenSuccess, TestTokenDecline, TestTokenTimeout, TestTokenPending:
+		return req.CardToken
+	}
+	switch helpers.ToCents(req.Amount) % 100 {
+	case 1:
+		return TestTokenDecline
+	case 2:
+		return TestTokenTimeout
+	case 3:
+		return TestTokenPending
+	}
+	return TestTokenSuccess
+}
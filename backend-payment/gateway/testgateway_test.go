@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTestGatewayAuthorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        AuthorizeRequest
+		wantStatus string
+		wantErr    error
+	}{
+		{"success token", AuthorizeRequest{Amount: 10.01, CardToken: TestTokenSuccess}, StatusAuthorized, nil},
+		{"decline token", AuthorizeRequest{Amount: 10, CardToken: TestTokenDecline}, StatusDeclined, nil},
+		{"timeout token", AuthorizeRequest{Amount: 10, CardToken: TestTokenTimeout}, "", ErrTimeout},
+		{"pending token", AuthorizeRequest{Amount: 10, CardToken: TestTokenPending}, StatusPending, nil},
+		{"approved amount", AuthorizeRequest{Amount: 10}, StatusAuthorized, nil},
+		{"declined amount", AuthorizeRequest{Amount: 10.01}, StatusDeclined, nil},
+		{"timeout amount", AuthorizeRequest{Amount: 10.02}, "", ErrTimeout},
+		{"pending amount", AuthorizeRequest{Amount: 10.03}, StatusPending, nil},
+		{"unknown token uses the amount", AuthorizeRequest{Amount: 0.01, CardToken: "tok_visa"}, StatusDeclined, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewTestGateway().Authorize(context.Background(), tt.req)
+			if err != tt.wantErr {
+				t.Fatalf("Authorize() error = %v, want %v", err, tt.wantErr)
+			}
+			if result.Status != tt.wantStatus {
+				t.Errorf("Authorize() status = %q, want %q", result.Status, tt.wantStatus)
+			}
+			if tt.wantErr == nil && result.Reference == "" {
+				t.Error("Authorize() returned no reference")
+			}
+		})
+	}
+}
+
+func TestTestGatewayLookup(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus string
+		wantErr    error
+	}{
+		{"authorized", TestTokenSuccess, StatusAuthorized, nil},
+		{"declined", TestTokenDecline, StatusDeclined, nil},
+		{"pending", TestTokenPending, StatusPending, nil},
+		{"timed out before reaching the provider", TestTokenTimeout, "", ErrUnknownPayment},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := NewTestGateway()
+			req := AuthorizeRequest{Amount: 10, CardToken: tt.token, IdempotencyKey: "attempt-1"}
+			authorized, _ := gw.Authorize(ctx, req)
+
+			result, err := gw.Lookup(ctx, "attempt-1")
+			if err != tt.wantErr {
+				t.Fatalf("Lookup() error = %v, want %v", err, tt.wantErr)
+			}
+			if result.Status != tt.wantStatus || result.Reference != authorized.Reference {
+				t.Errorf("Lookup() = %+v, want %s payment %q", result, tt.wantStatus, authorized.Reference)
+			}
+		})
+	}
+}
+
+func TestTestGatewayAuthorizeIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	gw := NewTestGateway()
+
+	req := AuthorizeRequest{Amount: 10, IdempotencyKey: "attempt-1"}
+	first, err := gw.Authorize(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := gw.Authorize(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Reference != second.Reference {
+		t.Errorf("repeated Authorize() created %q and %q, want one payment", first.Reference, second.Reference)
+	}
+}
+
+func TestTestGatewayLifecycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(ctx context.Context, gw *TestGateway, reference string) (Result, error)
+		want    string
+		wantErr bool
+	}{
+		{"capture", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			return gw.Capture(ctx, reference, 10)
+		}, StatusCaptured, false},
+		{"capture more than authorized", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			return gw.Capture(ctx, reference, 10.5)
+		}, "", true},
+		{"void", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			return gw.Void(ctx, reference)
+		}, StatusVoided, false},
+		{"refund before capture", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			return gw.Refund(ctx, reference, 5, "")
+		}, "", true},
+		{"partial refunds up to the captured amount", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			if _, err := gw.Capture(ctx, reference, 10); err != nil {
+				return Result{}, err
+			}
+			for _, amount := range []float64{3.3, 3.3, 3.4} {
+				if _, err := gw.Refund(ctx, reference, amount, ""); err != nil {
+					return Result{}, err
+				}
+			}
+			return gw.Status(ctx, reference)
+		}, StatusRefunded, false},
+		{"refund more than captured", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			if _, err := gw.Capture(ctx, reference, 10); err != nil {
+				return Result{}, err
+			}
+			return gw.Refund(ctx, reference, 10.01, "")
+		}, "", true},
+		{"refund sent again with the same key", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			if _, err := gw.Capture(ctx, reference, 10); err != nil {
+				return Result{}, err
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := gw.Refund(ctx, reference, 6, "refund-1"); err != nil {
+					return Result{}, err
+				}
+			}
+			// Refunding twice would exceed the captured amount
+			return gw.Refund(ctx, reference, 4, "refund-2")
+		}, StatusRefunded, false},
+		{"void after capture", func(ctx context.Context, gw *TestGateway, reference string) (Result, error) {
+			if _, err := gw.Capture(ctx, reference, 10); err != nil {
+				return Result{}, err
+			}
+			return gw.Void(ctx, reference)
+		}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			gw := NewTestGateway()
+			authorized, err := gw.Authorize(ctx, AuthorizeRequest{Amount: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := tt.run(ctx, gw, authorized.Reference)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result.Status != tt.want {
+				t.Errorf("status = %q, want %q", result.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusOfUnknownPayment(t *testing.T) {
+	if _, err := NewTestGateway().Status(context.Background(), "test_missing"); err != ErrUnknownPayment {
+		t.Errorf("Status() error = %v, want ErrUnknownPayment", err)
+	}
+}
@@ -0,0 +1,157 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Principal is the caller of a request, as described by backend-order
+type Principal struct {
+	UserID        string `json:"user_id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	// Permissions are granted by the roles of the user, restricted to the
+	// scopes of the API key
+	Permissions []string `json:"permissions"`
+	// Scopes are set for API keys only, access tokens are not scoped
+	Scopes           []string `json:"scopes,omitempty"`
+	APIKey           bool     `json:"api_key"`
+	MFA              bool     `json:"mfa"`
+	AdminMFARequired bool     `json:"admin_mfa_required"`
+}
+
+// HasScope tells whether the credential may be used for the scope
+func (p Principal) HasScope(scope string) bool {
+	if !p.APIKey {
+		return true
+	}
+	return contains(p.Scopes, scope)
+}
+
+// Granted tells whether the roles of the caller, and the scopes of the API
+// key, grant the permission
+func (p Principal) Granted(permission string) bool {
+	return contains(p.Permissions, permission)
+}
+
+// HasPermission tells whether the caller holds the permission, from a
+// session opened with two-factor authentication when admin access requires it
+func (p Principal) HasPermission(permission string) bool {
+	return p.Granted(permission) && (p.MFA || !p.AdminMFARequired)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IntrospectionError is the refusal of a credential by backend-order, with
+// the status and message to respond with
+type IntrospectionError struct {
+	Status  int
+	Message string
+}
+
+func (e *IntrospectionError) Error() string { return e.Message }
+
+// introspectionCacheTTL bounds how long a revoked credential keeps working
+const introspectionCacheTTL = 30 * time.Second
+
+type cachedPrincipal struct {
+	principal Principal
+	expiresAt time.Time
+}
+
+var (
+	introspectionMu     sync.Mutex
+	introspectionCache  = map[[32]byte]cachedPrincipal{}
+	introspectionClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Introspect asks backend-order who the Authorization header belongs to.
+// Answers are cached for 30 seconds.
+func Introspect(ctx context.Context, authorization string) (Principal, error) {
+	cacheKey := sha256.Sum256([]byte(authorization))
+	now := time.Now()
+
+	introspectionMu.Lock()
+	cached, ok := introspectionCache[cacheKey]
+	introspectionMu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.principal, nil
+	}
+
+	principal, err := introspect(ctx, authorization)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	introspectionMu.Lock()
+	defer introspectionMu.Unlock()
+	if len(introspectionCache) >= 10000 {
+		for key, entry := range introspectionCache {
+			if now.After(entry.expiresAt) {
+				delete(introspectionCache, key)
+			}
+		}
+	}
+	introspectionCache[cacheKey] = cachedPrincipal{principal: principal, expiresAt: now.Add(introspectionCacheTTL)}
+	return principal, nil
+}
+
+func introspect(ctx context.Context, authorization string) (Principal, error) {
+	orderServiceURL := os.Getenv("API_ORDER_URL")
+	if orderServiceURL == "" {
+		return Principal{}, fmt.Errorf("API_ORDER_URL environment variable is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orderServiceURL+"/backend/auth/introspect", nil)
+	if err != nil {
+		return Principal{}, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	timestamp := time.Now()
+	req.Header.Set(SignatureHeader, SignRequest(req.Method, req.URL.Path, nil, timestamp, authorization))
+	req.Header.Set(TimestampHeader, timestamp.Format(time.RFC3339))
+
+	resp, err := introspectionClient.Do(req)
+	if err != nil {
+		return Principal{}, fmt.Errorf("failed to reach order service: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Principal{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var principal Principal
+		if err := json.Unmarshal(body, &principal); err != nil {
+			return Principal{}, fmt.Errorf("failed to decode introspection: %w", err)
+		}
+		return principal, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		var refusal struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(body, &refusal)
+		if refusal.Error == "" {
+			refusal.Error = "Invalid or expired token"
+		}
+		return Principal{}, &IntrospectionError{Status: resp.StatusCode, Message: refusal.Error}
+	}
+	return Principal{}, fmt.Errorf("order service responded with status code: %d", resp.StatusCode)
+}
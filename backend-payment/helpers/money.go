@@ -0,0 +1,9 @@
+package helpers
+
+import "math"
+
+// ToCents converts an amount to whole cents, so that amounts are compared
+// without float rounding errors
+func ToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,151 @@
+// This file is a copy of backend-order/helpers/pagination.go, as the services
+// don't share a module and are built on their own. Keep the two in sync:
+// pagination_test.go, copied as well, pins the behaviour of both.
+
+package helpers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is the response envelope of paginated listings
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int64  `json:"total"`
+}
+
+// SortKey is one field of a sort order
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses a comma separated list of sort keys such as "-price,name".
+// allowed maps the names accepted from clients to document fields. The result
+// always ends with _id so that the order is total and cursors are stable.
+func ParseSort(param string, allowed map[string]string, fallback string) ([]SortKey, error) {
+	if param == "" {
+		param = fallback
+	}
+
+	var keys []SortKey
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		field, ok := allowed[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported sort key %q", name)
+		}
+		keys = append(keys, SortKey{Field: field, Desc: desc})
+	}
+
+	return append(keys, SortKey{Field: "_id"}), nil
+}
+
+// SortFields returns the keys in the format expected by qmgo's Sort
+func SortFields(keys []SortKey) []string {
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key.Desc {
+			fields = append(fields, "-"+key.Field)
+		} else {
+			fields = append(fields, key.Field)
+		}
+	}
+	return fields
+}
+
+// ParseLimit reads a page size, falling back to DefaultPageLimit and capping at MaxPageLimit
+func ParseLimit(param string) (int64, error) {
+	if param == "" {
+		return DefaultPageLimit, nil
+	}
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit %q", param)
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return limit, nil
+}
+
+type cursorValues struct {
+	Values bson.A `bson:"v"`
+}
+
+// EncodeCursor returns a cursor pointing after doc in the given sort order
+func EncodeCursor(keys []SortKey, doc interface{}) (string, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+
+	values := make(bson.A, 0, len(keys))
+	for _, key := range keys {
+		value, err := bson.Raw(raw).LookupErr(strings.Split(key.Field, ".")...)
+		if err != nil {
+			// Missing fields sort like null
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, value)
+	}
+
+	// Extended JSON keeps dates and ObjectIDs typed through the round trip
+	encoded, err := bson.MarshalExtJSON(cursorValues{Values: values}, true, false)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// CursorFilter returns the filter selecting the documents after the cursor
+func CursorFilter(keys []SortKey, cursor string) (bson.M, error) {
+	encoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var decoded cursorValues
+	if err := bson.UnmarshalExtJSON(encoded, true, &decoded); err != nil || len(decoded.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+	if _, ok := decoded.Values[len(keys)-1].(primitive.ObjectID); !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	// (k1 > v1) or (k1 = v1 and k2 > v2) or ...
+	or := make([]bson.M, 0, len(keys))
+	for i, key := range keys {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[keys[j].Field] = decoded.Values[j]
+		}
+		op := "$gt"
+		if key.Desc {
+			op = "$lt"
+		}
+		clause[key.Field] = bson.M{op: decoded.Values[i]}
+		or = append(or, clause)
+	}
+
+	return bson.M{"$or": or}, nil
+}
@@ -0,0 +1,149 @@
+// This file is a copy of backend-order/helpers/pagination_test.go. It pins
+// the cursor format and limits, so that the two copies of pagination.go
+// can't drift apart without a test failing.
+
+package helpers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A cursor encoded with the keys and document of paginationFixture. Cursors
+// handed out by one version must keep working with the next, so changing it
+// breaks the clients holding one.
+const paginationGoldenCursor = "eyJ2IjpbeyIkZGF0ZSI6eyIkbnVtYmVyTG9uZyI6IjE3MTQ1NjQ4MDAwMDAifX0seyIkbnVtYmVyRG91YmxlIjoiMTIuNSJ9LCJBZGEiLG51bGwseyIkb2lkIjoiNjRiN2YwYzJhMWIyYzNkNGU1ZjYwNzE4In1dfQ"
+
+func paginationFixture(t *testing.T) ([]SortKey, bson.M, primitive.ObjectID, time.Time) {
+	t.Helper()
+	id, err := primitive.ObjectIDFromHex("64b7f0c2a1b2c3d4e5f60718")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	keys := []SortKey{
+		{Field: "created_at", Desc: true},
+		{Field: "amount"},
+		{Field: "customer.name"},
+		{Field: "missing"},
+		{Field: "_id"},
+	}
+	doc := bson.M{"_id": id, "amount": 12.5, "created_at": createdAt, "customer": bson.M{"name": "Ada"}}
+	return keys, doc, id, createdAt
+}
+
+func TestEncodeCursor(t *testing.T) {
+	keys, doc, _, _ := paginationFixture(t)
+	cursor, err := EncodeCursor(keys, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != paginationGoldenCursor {
+		t.Errorf("EncodeCursor() = %s, want %s", cursor, paginationGoldenCursor)
+	}
+}
+
+func TestCursorFilter(t *testing.T) {
+	keys, _, id, createdAt := paginationFixture(t)
+	filter, err := CursorFilter(keys, paginationGoldenCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := primitive.NewDateTimeFromTime(createdAt)
+	want := bson.M{"$or": []bson.M{
+		{"created_at": bson.M{"$lt": date}},
+		{"created_at": date, "amount": bson.M{"$gt": 12.5}},
+		{"created_at": date, "amount": 12.5, "customer.name": bson.M{"$gt": "Ada"}},
+		{"created_at": date, "amount": 12.5, "customer.name": "Ada", "missing": bson.M{"$gt": nil}},
+		{"created_at": date, "amount": 12.5, "customer.name": "Ada", "missing": nil, "_id": bson.M{"$gt": id}},
+	}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("CursorFilter() = %v, want %v", filter, want)
+	}
+}
+
+func TestCursorFilterRejectsInvalidCursors(t *testing.T) {
+	keys, doc, _, _ := paginationFixture(t)
+	withoutID, err := EncodeCursor(keys[:4], doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		keys   []SortKey
+		cursor string
+	}{
+		{"not base64", keys, "not a cursor!"},
+		{"not extended JSON", keys, "bm90IGpzb24"},
+		{"other number of keys", keys[1:], paginationGoldenCursor},
+		{"not ending with an ObjectID", keys[:4], withoutID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CursorFilter(tt.keys, tt.cursor); err != ErrInvalidCursor {
+				t.Errorf("CursorFilter() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		param   string
+		want    int64
+		wantErr bool
+	}{
+		{"", DefaultPageLimit, false},
+		{"5", 5, false},
+		{"100", 100, false},
+		{"1000", MaxPageLimit, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"ten", 0, true},
+	}
+	if DefaultPageLimit != 20 || MaxPageLimit != 100 {
+		t.Errorf("page limits = %d and %d, want 20 and 100", DefaultPageLimit, MaxPageLimit)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			got, err := ParseLimit(tt.param)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseLimit(%q) = %d, %v, want %d, error %v", tt.param, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	allowed := map[string]string{"price": "price", "name": "name", "created": "created_at"}
+	tests := []struct {
+		param   string
+		want    []SortKey
+		wantErr bool
+	}{
+		{"", []SortKey{{Field: "created_at", Desc: true}, {Field: "_id"}}, false},
+		{"-price,name", []SortKey{{Field: "price", Desc: true}, {Field: "name"}, {Field: "_id"}}, false},
+		{" name , -created ", []SortKey{{Field: "name"}, {Field: "created_at", Desc: true}, {Field: "_id"}}, false},
+		{"stock", nil, true},
+		{"price,", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			got, err := ParseSort(tt.param, allowed, "-created")
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSort(%q) = %v, %v, want %v, error %v", tt.param, got, err, tt.want, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(SortFields(got)[len(got)-1:], []string{"_id"}) {
+				t.Errorf("SortFields() doesn't end with _id")
+			}
+		})
+	}
+}
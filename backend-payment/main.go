@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
+	"backend-payment/database"
 	docs "backend-payment/docs"
+	"backend-payment/gateway"
 	"backend-payment/middleware"
 	"backend-payment/routes"
+	"backend-payment/routes/api"
 )
 
 // @title Payment API
@@ -21,6 +26,10 @@ import (
 // @description This is a payment service API.
 // @BasePath /
 
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
+
 func main() {
 	// Load .env file
 	err := godotenv.Load()
@@ -28,6 +37,13 @@ func main() {
 		log.Println("Error loading .env file, using environment variables")
 	}
 
+	// Fail on startup rather than on the first payment if misconfigured
+	gateway.Default()
+
+	if err := database.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Error creating database indexes: %v", err)
+	}
+
 	// Create a new Gin router
 	r := gin.Default()
 
@@ -59,6 +75,9 @@ func main() {
 	// Add Swagger documentation route
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Start background job
+	go runBackgroundJob()
+
 	// Start the server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -68,3 +87,18 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+func runBackgroundJob() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			api.SettlePendingPayments()
+			api.NotifyPendingPayments()
+			api.SettlePendingRefunds()
+			api.NotifyPendingRefunds()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"backend-payment/helpers"
+)
+
+// Permissions of backend-order that grant access to payments
+const (
+	PermissionOrdersRead   = "orders:read"
+	PermissionOrdersWrite  = "orders:write"
+	PermissionOrdersRefund = "orders:refund"
+)
+
+// AuthMiddleware authenticates the request with the access token or API key
+// issued by backend-order, and stores the caller in the context
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := c.GetHeader("Authorization")
+		if strings.TrimPrefix(authorization, "Bearer ") == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization token"})
+			c.Abort()
+			return
+		}
+
+		principal, err := helpers.Introspect(c.Request.Context(), authorization)
+		if refusal, ok := err.(*helpers.IntrospectionError); ok {
+			c.JSON(refusal.Status, gin.H{"error": refusal.Message})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating request: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Authentication service unavailable"})
+			c.Abort()
+			return
+		}
+
+		c.Set("principal", principal)
+		c.Next()
+	}
+}
+
+// CurrentPrincipal returns the caller stored by AuthMiddleware
+func CurrentPrincipal(c *gin.Context) helpers.Principal {
+	principal, _ := c.Get("principal")
+	p, _ := principal.(helpers.Principal)
+	return p
+}
+
+// RequireScope rejects requests authenticated with an API key that doesn't
+// grant the scope. It must run after AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CurrentPrincipal(c).HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key scope " + scope + " required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission only lets through callers holding the permission, from a
+// session opened with two-factor authentication when backend-order requires
+// it for admin access. It must run after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := CurrentPrincipal(c)
+		switch {
+		case principal.HasPermission(permission):
+			c.Next()
+			return
+		case principal.Granted(permission):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for admin access"})
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + permission + " required"})
+		}
+		c.Abort()
+	}
+}
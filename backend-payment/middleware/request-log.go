@@ -70,6 +70,10 @@ func LoggerMiddleware() gin.HandlerFunc {
 		for k, v := range c.Request.Header {
 			entry.RequestHeader[k] = v[0]
 		}
+		// Credentials must not end up in the logs
+		if _, ok := entry.RequestHeader["Authorization"]; ok {
+			entry.RequestHeader["Authorization"] = "[redacted]"
+		}
 		for k, v := range c.Writer.Header() {
 			entry.ResponseHeader[k] = v[0]
 		}
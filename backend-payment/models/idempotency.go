@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// IdempotencyKeyLifetime is how long a response is replayed for its key
+	IdempotencyKeyLifetime = 24 * time.Hour
+	// IdempotencyLockTimeout is how long a request holds its key before a
+	// repeat may take over, in case it never completed
+	IdempotencyLockTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes with another request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+	// ErrIdempotencyKeyInProgress is returned while the first request with the key runs
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key in progress")
+)
+
+// IdempotencyKey records the response to a request sent with an
+// Idempotency-Key header, so that retries get it instead of running again.
+// The fingerprint identifies the request the key was first used with.
+type IdempotencyKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Key         string             `bson:"key"`
+	Fingerprint string             `bson:"fingerprint"`
+	// StatusCode and Response are empty while the request runs
+	StatusCode int       `bson:"status_code,omitempty"`
+	Response   []byte    `bson:"response,omitempty"`
+	CreatedAt  time.Time `bson:"created_at"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+}
+
+// ClaimIdempotencyKey reserves the key for a request. It returns nil when the
+// request should run, and the recorded key when its response should be
+// replayed.
+func ClaimIdempotencyKey(ctx context.Context, keys *qmgo.Collection, key, fingerprint string) (*IdempotencyKey, error) {
+	now := time.Now()
+	_, err := keys.InsertOne(ctx, IdempotencyKey{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(IdempotencyKeyLifetime),
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !qmgo.IsDup(err) {
+		return nil, err
+	}
+
+	var existing IdempotencyKey
+	if err := keys.Find(ctx, bson.M{"key": key}).One(&existing); err != nil {
+		return nil, err
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if existing.StatusCode != 0 {
+		return &existing, nil
+	}
+
+	// Take over a request that never completed, unless another repeat did
+	err = keys.UpdateOne(ctx, bson.M{
+		"_id":         existing.ID,
+		"status_code": bson.M{"$exists": false},
+		"created_at":  bson.M{"$lt": now.Add(-IdempotencyLockTimeout)},
+	}, bson.M{"$set": bson.M{"created_at": now, "expires_at": now.Add(IdempotencyKeyLifetime)}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return nil, err
+}
+
+// CompleteIdempotencyKey records the response to replay for the key
+func CompleteIdempotencyKey(ctx context.Context, keys *qmgo.Collection, key string, statusCode int, response []byte) error {
+	return keys.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$set": bson.M{
+		"status_code": statusCode,
+		"response":    response,
+	}})
+}
+
+// ReleaseIdempotencyKey forgets the key, so that the request can be retried
+func ReleaseIdempotencyKey(ctx context.Context, keys *qmgo.Collection, key string) error {
+	return keys.Remove(ctx, bson.M{"key": key})
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	mongooptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func testIdempotencyKeys(t *testing.T) *qmgo.Collection {
+	t.Helper()
+	keys := testDatabase(t).Collection("idempotency_keys")
+	// Claims rely on the unique index of database.EnsureIndexes
+	err := keys.CreateOneIndex(context.Background(), options.IndexModel{
+		Key:          []string{"key"},
+		IndexOptions: mongooptions.Index().SetUnique(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	claim := func(ctx context.Context, keys *qmgo.Collection, key string) error {
+		_, err := ClaimIdempotencyKey(ctx, keys, key, "request")
+		return err
+	}
+
+	tests := []struct {
+		name        string
+		setup       func(ctx context.Context, keys *qmgo.Collection, key string) error
+		fingerprint string
+		wantErr     error
+		// wantReplay is the status code of the recorded response, 0 when
+		// the request should run
+		wantReplay int
+	}{
+		{"first use runs", nil, "request", nil, 0},
+		{"repeat while the first runs", claim, "request", ErrIdempotencyKeyInProgress, 0},
+		{"other request while the first runs", claim, "other", ErrIdempotencyKeyReused, 0},
+		{"repeat once completed replays", func(ctx context.Context, keys *qmgo.Collection, key string) error {
+			if err := claim(ctx, keys, key); err != nil {
+				return err
+			}
+			return CompleteIdempotencyKey(ctx, keys, key, 201, []byte(`{"id":"1"}`))
+		}, "request", nil, 201},
+		{"other request once completed", func(ctx context.Context, keys *qmgo.Collection, key string) error {
+			if err := claim(ctx, keys, key); err != nil {
+				return err
+			}
+			return CompleteIdempotencyKey(ctx, keys, key, 201, []byte(`{"id":"1"}`))
+		}, "other", ErrIdempotencyKeyReused, 0},
+		{"repeat once released runs", func(ctx context.Context, keys *qmgo.Collection, key string) error {
+			if err := claim(ctx, keys, key); err != nil {
+				return err
+			}
+			return ReleaseIdempotencyKey(ctx, keys, key)
+		}, "request", nil, 0},
+		{"repeat of an abandoned request takes over", func(ctx context.Context, keys *qmgo.Collection, key string) error {
+			if err := claim(ctx, keys, key); err != nil {
+				return err
+			}
+			return keys.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$set": bson.M{
+				"created_at": time.Now().Add(-IdempotencyLockTimeout - time.Second),
+			}})
+		}, "request", nil, 0},
+	}
+
+	keys := testIdempotencyKeys(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			key := "user:" + tt.name
+			if tt.setup != nil {
+				if err := tt.setup(ctx, keys, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			recorded, err := ClaimIdempotencyKey(ctx, keys, key, tt.fingerprint)
+			if err != tt.wantErr {
+				t.Fatalf("ClaimIdempotencyKey() error = %v, want %v", err, tt.wantErr)
+			}
+			switch {
+			case tt.wantReplay == 0 && recorded != nil:
+				t.Errorf("ClaimIdempotencyKey() replays %d, want the request to run", recorded.StatusCode)
+			case tt.wantReplay != 0 && recorded == nil:
+				t.Errorf("ClaimIdempotencyKey() runs the request, want a replay of %d", tt.wantReplay)
+			case tt.wantReplay != 0 && recorded.StatusCode != tt.wantReplay:
+				t.Errorf("replayed status = %d, want %d", recorded.StatusCode, tt.wantReplay)
+			}
+		})
+	}
+}
+
+func TestClaimIdempotencyKeyTakeOverOnce(t *testing.T) {
+	ctx := context.Background()
+	keys := testIdempotencyKeys(t)
+
+	if _, err := ClaimIdempotencyKey(ctx, keys, "key", "request"); err != nil {
+		t.Fatal(err)
+	}
+	err := keys.UpdateOne(ctx, bson.M{"key": "key"}, bson.M{"$set": bson.M{
+		"created_at": time.Now().Add(-IdempotencyLockTimeout - time.Second),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The repeat taking over holds the key again
+	if _, err := ClaimIdempotencyKey(ctx, keys, "key", "request"); err != nil {
+		t.Fatalf("first repeat: %v", err)
+	}
+	if _, err := ClaimIdempotencyKey(ctx, keys, "key", "request"); err != ErrIdempotencyKeyInProgress {
+		t.Fatalf("second repeat error = %v, want ErrIdempotencyKeyInProgress", err)
+	}
+}
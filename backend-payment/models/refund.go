@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-payment/helpers"
+)
+
+const (
+	// RefundStatusPending refunds are sent to the gateway, or weren't
+	// answered by it, and keep their amount reserved
+	RefundStatusPending   = "Pending"
+	RefundStatusCompleted = "Completed"
+	RefundStatusFailed    = "Failed"
+)
+
+// ErrRefundExceedsCaptured is returned when refunds would exceed the captured amount
+var ErrRefundExceedsCaptured = errors.New("refunds exceed the captured amount")
+
+// Refund gives back part or all of the amount captured by a transaction.
+// Failed refunds are kept for the record.
+type Refund struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TransactionID    primitive.ObjectID `json:"transaction_id" bson:"transaction_id"`
+	OrderID          string             `json:"order_id" bson:"order_id"`
+	Amount           float64            `json:"amount" bson:"amount"`
+	Currency         string             `json:"currency" bson:"currency"`
+	Reason           string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Restock          bool               `json:"restock" bson:"restock"`
+	Status           string             `json:"status" bson:"status"`
+	GatewayReference string             `json:"gateway_reference,omitempty" bson:"gateway_reference,omitempty"`
+	FailureReason    string             `json:"failure_reason,omitempty" bson:"failure_reason,omitempty"`
+	// RefundedTotal is what the transaction has refunded, this refund included
+	RefundedTotal float64 `json:"refunded_total" bson:"refunded_total"`
+	// OrderNotified is false until backend-order recorded a completed refund
+	OrderNotified bool `json:"order_notified" bson:"order_notified"`
+	// NotificationFailure is why backend-order refused the refund. It isn't
+	// sent again and needs to be looked at by hand.
+	NotificationFailure string    `json:"notification_failure,omitempty" bson:"notification_failure,omitempty"`
+	CreatedAt           time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// RefundableAmount returns what is left to refund of the transaction
+func (t Transaction) RefundableAmount() float64 {
+	if t.Status != TransactionStatusCompleted && t.Status != TransactionStatusPartiallyRefunded {
+		return 0
+	}
+	return float64(helpers.ToCents(t.Amount)-helpers.ToCents(t.RefundedAmount)) / 100
+}
+
+// ReserveRefund adds the amount to the refunded amount of the transaction,
+// unless it would exceed the captured amount, and returns the updated
+// transaction. Reserving before calling the gateway keeps concurrent refunds
+// under the cap; release the amount if the gateway refuses the refund.
+func ReserveRefund(ctx context.Context, transactions *qmgo.Collection, transactionID primitive.ObjectID, amount float64) (Transaction, error) {
+	var transaction Transaction
+	if err := transactions.Find(ctx, bson.M{"_id": transactionID}).One(&transaction); err != nil {
+		return Transaction{}, err
+	}
+	if helpers.ToCents(amount) > helpers.ToCents(transaction.RefundableAmount()) {
+		return Transaction{}, ErrRefundExceedsCaptured
+	}
+
+	// Half a cent of margin absorbs float rounding
+	limit := transaction.Amount - amount + 0.005
+	err := transactions.Find(ctx, bson.M{
+		"_id":    transactionID,
+		"status": bson.M{"$in": []string{TransactionStatusCompleted, TransactionStatusPartiallyRefunded}},
+		"$or": []bson.M{
+			{"refunded_amount": bson.M{"$lte": limit}},
+			{"refunded_amount": bson.M{"$exists": false}},
+		},
+	}).Apply(qmgo.Change{
+		Update:    bson.M{"$inc": bson.M{"refunded_amount": amount}, "$set": bson.M{"updated_at": time.Now()}},
+		ReturnNew: true,
+	}, &transaction)
+	if err == qmgo.ErrNoSuchDocuments {
+		return Transaction{}, ErrRefundExceedsCaptured
+	}
+	return transaction, err
+}
+
+// ReleaseRefund gives back a reserved amount the gateway didn't refund
+func ReleaseRefund(ctx context.Context, transactions *qmgo.Collection, transactionID primitive.ObjectID, amount float64) error {
+	return transactions.UpdateOne(ctx, bson.M{"_id": transactionID}, bson.M{
+		"$inc": bson.M{"refunded_amount": -amount},
+		"$set": bson.M{"updated_at": time.Now()},
+	})
+}
+
+// SettleRefund sets the status of the transaction after a refund, from the
+// refunded amount it had once the refund was reserved
+func SettleRefund(ctx context.Context, transactions *qmgo.Collection, transaction Transaction) error {
+	filter := bson.M{"_id": transaction.ID}
+	status := TransactionStatusRefunded
+	if helpers.ToCents(transaction.RefundedAmount) < helpers.ToCents(transaction.Amount) {
+		status = TransactionStatusPartiallyRefunded
+		// A concurrent refund may have completed the transaction already
+		filter["status"] = bson.M{"$ne": TransactionStatusRefunded}
+	}
+	err := transactions.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return nil
+	}
+	return err
+}
+
+// RecordRefundOutcome stores the answer of the gateway to a pending refund.
+// It returns qmgo.ErrNoSuchDocuments if the refund was settled already.
+func RecordRefundOutcome(ctx context.Context, refunds *qmgo.Collection, refund *Refund) error {
+	refund.UpdatedAt = time.Now()
+	return refunds.UpdateOne(ctx, bson.M{"_id": refund.ID, "status": RefundStatusPending}, bson.M{"$set": bson.M{
+		"status":            refund.Status,
+		"gateway_reference": refund.GatewayReference,
+		"failure_reason":    refund.FailureReason,
+		"updated_at":        refund.UpdatedAt,
+	}})
+}
+
+// ListPendingRefunds returns the refunds the gateway hasn't answered, created
+// before the time so that those being sent right now are left alone, oldest
+// first
+func ListPendingRefunds(ctx context.Context, refunds *qmgo.Collection, before time.Time) ([]Refund, error) {
+	result := []Refund{}
+	err := refunds.Find(ctx, bson.M{
+		"status":     RefundStatusPending,
+		"created_at": bson.M{"$lt": before},
+	}).Sort("created_at").All(&result)
+	return result, err
+}
+
+// MarkRefundNotified records that backend-order knows about the refund
+func MarkRefundNotified(ctx context.Context, refunds *qmgo.Collection, refundID primitive.ObjectID) error {
+	return refunds.UpdateOne(ctx, bson.M{"_id": refundID}, bson.M{"$set": bson.M{
+		"order_notified": true,
+		"updated_at":     time.Now(),
+	}})
+}
+
+// MarkRefundNotificationFailed stops sending the refund to backend-order,
+// which refused it for the reason
+func MarkRefundNotificationFailed(ctx context.Context, refunds *qmgo.Collection, refundID primitive.ObjectID, reason string) error {
+	return refunds.UpdateOne(ctx, bson.M{"_id": refundID}, bson.M{"$set": bson.M{
+		"notification_failure": reason,
+		"updated_at":           time.Now(),
+	}})
+}
+
+// ListUnnotifiedRefunds returns the completed refunds backend-order hasn't
+// recorded yet and didn't refuse, oldest first
+func ListUnnotifiedRefunds(ctx context.Context, refunds *qmgo.Collection) ([]Refund, error) {
+	result := []Refund{}
+	err := refunds.Find(ctx, bson.M{
+		"status":               RefundStatusCompleted,
+		"order_notified":       false,
+		"notification_failure": bson.M{"$exists": false},
+	}).Sort("created_at").All(&result)
+	return result, err
+}
+
+// ListRefunds returns the refunds of the transaction, oldest first
+func ListRefunds(ctx context.Context, refunds *qmgo.Collection, transactionID primitive.ObjectID) ([]Refund, error) {
+	result := []Refund{}
+	err := refunds.Find(ctx, bson.M{"transaction_id": transactionID}).Sort("created_at").All(&result)
+	return result, err
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-payment/helpers"
+)
+
+func TestRefundableAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		amount   float64
+		refunded float64
+		want     float64
+	}{
+		{"completed", TransactionStatusCompleted, 10, 0, 10},
+		{"partially refunded", TransactionStatusPartiallyRefunded, 10, 3.3, 6.7},
+		{"one cent left", TransactionStatusPartiallyRefunded, 10, 9.99, 0.01},
+		{"float rounding of the refunds", TransactionStatusPartiallyRefunded, 0.3, 0.1 + 0.2, 0},
+		{"refunded", TransactionStatusRefunded, 10, 10, 0},
+		{"pending", TransactionStatusPending, 10, 0, 0},
+		{"failed", TransactionStatusFailed, 10, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transaction := Transaction{Status: tt.status, Amount: tt.amount, RefundedAmount: tt.refunded}
+			if got := transaction.RefundableAmount(); got != tt.want {
+				t.Errorf("RefundableAmount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReserveRefund(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		refunded float64
+		amount   float64
+		wantErr  error
+		want     float64
+	}{
+		{"part of a completed payment", TransactionStatusCompleted, 0, 4, nil, 4},
+		{"the rest of a partially refunded payment", TransactionStatusPartiallyRefunded, 3.3 + 3.3, 3.4, nil, 10},
+		{"a cent more than left", TransactionStatusPartiallyRefunded, 6, 4.01, ErrRefundExceedsCaptured, 6},
+		{"a refunded payment", TransactionStatusRefunded, 10, 0.01, ErrRefundExceedsCaptured, 10},
+		{"a pending payment", TransactionStatusPending, 0, 1, ErrRefundExceedsCaptured, 0},
+	}
+
+	transactions := testDatabase(t).Collection("transactions")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			id := primitive.NewObjectID()
+			_, err := transactions.InsertOne(ctx, Transaction{ID: id, Amount: 10, Status: tt.status, RefundedAmount: tt.refunded})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ReserveRefund(ctx, transactions, id, tt.amount)
+			if err != tt.wantErr {
+				t.Fatalf("ReserveRefund() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var stored Transaction
+			if err := transactions.Find(ctx, bson.M{"_id": id}).One(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if helpers.ToCents(stored.RefundedAmount) != helpers.ToCents(tt.want) {
+				t.Errorf("refunded amount = %v, want %v", stored.RefundedAmount, tt.want)
+			}
+		})
+	}
+}
+
+func TestReserveRefundOfPaymentWithoutRefundedAmount(t *testing.T) {
+	ctx := context.Background()
+	transactions := testDatabase(t).Collection("transactions")
+
+	// Transactions created before refunds existed have no refunded_amount
+	id := primitive.NewObjectID()
+	if _, err := transactions.InsertOne(ctx, bson.M{"_id": id, "amount": 10.0, "status": TransactionStatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, err := ReserveRefund(ctx, transactions, id, 10)
+	if err != nil {
+		t.Fatalf("ReserveRefund() error = %v", err)
+	}
+	if transaction.RefundedAmount != 10 {
+		t.Errorf("refunded amount = %v, want 10", transaction.RefundedAmount)
+	}
+}
+
+func TestReserveRefundConcurrently(t *testing.T) {
+	ctx := context.Background()
+	transactions := testDatabase(t).Collection("transactions")
+
+	id := primitive.NewObjectID()
+	if _, err := transactions.InsertOne(ctx, Transaction{ID: id, Amount: 10, Status: TransactionStatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every request sees 10 left, only the update keeps them under the cap
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ReserveRefund(ctx, transactions, id, 3)
+			if err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			} else if err != ErrRefundExceedsCaptured {
+				t.Errorf("ReserveRefund() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var stored Transaction
+	if err := transactions.Find(ctx, bson.M{"_id": id}).One(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if reserved != 3 || stored.RefundedAmount != 9 {
+		t.Errorf("reserved %d refunds totalling %v, want 3 totalling 9", reserved, stored.RefundedAmount)
+	}
+}
+
+func TestSettleRefund(t *testing.T) {
+	tests := []struct {
+		name string
+		// stored is the status of the transaction when the refund settles
+		stored   string
+		refunded float64
+		want     string
+	}{
+		{"partial refund", TransactionStatusCompleted, 4, TransactionStatusPartiallyRefunded},
+		{"another partial refund", TransactionStatusPartiallyRefunded, 8, TransactionStatusPartiallyRefunded},
+		{"full refund", TransactionStatusPartiallyRefunded, 10, TransactionStatusRefunded},
+		{"full refund with float rounding", TransactionStatusPartiallyRefunded, 3.3 + 3.3 + 3.4, TransactionStatusRefunded},
+		{"partial refund settled after the full one", TransactionStatusRefunded, 6, TransactionStatusRefunded},
+	}
+
+	transactions := testDatabase(t).Collection("transactions")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			transaction := Transaction{ID: primitive.NewObjectID(), Amount: 10, Status: tt.stored, RefundedAmount: tt.refunded}
+			if _, err := transactions.InsertOne(ctx, transaction); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := SettleRefund(ctx, transactions, transaction); err != nil {
+				t.Fatalf("SettleRefund() error = %v", err)
+			}
+
+			var stored Transaction
+			if err := transactions.Find(ctx, bson.M{"_id": transaction.ID}).One(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.want {
+				t.Errorf("status = %s, want %s", stored.Status, tt.want)
+			}
+		})
+	}
+}
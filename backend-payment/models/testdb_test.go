@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testDatabase returns an empty database on the MongoDB server of
+// MONGODB_TEST_URI, dropped once the test is over. Tests using it are
+// skipped when the variable isn't set.
+func testDatabase(t *testing.T) *qmgo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	client, err := qmgo.NewClient(ctx, &qmgo.Config{Uri: uri})
+	if err != nil {
+		t.Fatalf("Failed to connect to the test database: %v", err)
+	}
+	db := client.Database("backend-payment-test-" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		if err := db.DropDatabase(ctx); err != nil {
+			t.Logf("Failed to drop %s: %v", db.GetDatabaseName(), err)
+		}
+		client.Close(ctx)
+	})
+	return db
+}
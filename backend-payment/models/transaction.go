@@ -1,22 +1,83 @@
 package models
 
 import (
+	"context"
 	"time"
 
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Transaction struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	OrderID   string             `json:"order_id" bson:"order_id"`
-	Amount    float64            `json:"amount" bson:"amount"`
-	Status    string             `json:"status" bson:"status"`
-	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrderID string             `json:"order_id" bson:"order_id"`
+	// UserID is the backend-order user who owns the order
+	UserID   string  `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Amount   float64 `json:"amount" bson:"amount"`
+	Currency string  `json:"currency" bson:"currency"`
+	Status   string  `json:"status" bson:"status"`
+	// Gateway is the provider that processed the payment, and
+	// GatewayReference identifies the payment there
+	Gateway          string `json:"gateway" bson:"gateway"`
+	GatewayReference string `json:"gateway_reference,omitempty" bson:"gateway_reference,omitempty"`
+	FailureReason    string `json:"failure_reason,omitempty" bson:"failure_reason,omitempty"`
+	// RefundedAmount is the total of the refunds, including those in progress
+	RefundedAmount float64 `json:"refunded_amount" bson:"refunded_amount"`
+	// ActiveOrderID is the order ID while the transaction is pending or
+	// completed, unique so that an order can't be paid twice
+	ActiveOrderID string `json:"-" bson:"active_order_id,omitempty"`
+	// OrderNotified is false until backend-order recorded the outcome of the
+	// settled transaction
+	OrderNotified bool `json:"order_notified" bson:"order_notified"`
+	// NotificationFailure is why backend-order refused the outcome. It isn't
+	// sent again and needs to be looked at by hand.
+	NotificationFailure string    `json:"notification_failure,omitempty" bson:"notification_failure,omitempty"`
+	CreatedAt           time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at" bson:"updated_at"`
 }
 
+// PendingPaymentTimeout is how long a pending transaction the gateway has no
+// trace of is given before it is considered failed
+const PendingPaymentTimeout = 15 * time.Minute
+
 const (
 	TransactionStatusPending   = "Pending"
 	TransactionStatusCompleted = "Completed"
 	TransactionStatusFailed    = "Failed"
+	// Completed transactions become partially refunded, then refunded
+	TransactionStatusPartiallyRefunded = "PartiallyRefunded"
+	TransactionStatusRefunded          = "Refunded"
 )
+
+// MarkTransactionNotified records that backend-order knows about the outcome
+// of the transaction
+func MarkTransactionNotified(ctx context.Context, transactions *qmgo.Collection, transactionID primitive.ObjectID) error {
+	return transactions.UpdateOne(ctx, bson.M{"_id": transactionID}, bson.M{"$set": bson.M{
+		"order_notified": true,
+		"updated_at":     time.Now(),
+	}})
+}
+
+// MarkTransactionNotificationFailed stops sending the outcome of the
+// transaction to backend-order, which refused it for the reason
+func MarkTransactionNotificationFailed(ctx context.Context, transactions *qmgo.Collection, transactionID primitive.ObjectID, reason string) error {
+	return transactions.UpdateOne(ctx, bson.M{"_id": transactionID}, bson.M{"$set": bson.M{
+		"notification_failure": reason,
+		"updated_at":           time.Now(),
+	}})
+}
+
+// ListUnnotifiedTransactions returns the settled transactions whose outcome
+// backend-order hasn't recorded yet, leaving those updated after before
+// alone as they are being notified, oldest first
+func ListUnnotifiedTransactions(ctx context.Context, transactions *qmgo.Collection, before time.Time) ([]Transaction, error) {
+	result := []Transaction{}
+	err := transactions.Find(ctx, bson.M{
+		"status":               bson.M{"$ne": TransactionStatusPending},
+		"order_notified":       false,
+		"notification_failure": bson.M{"$exists": false},
+		"updated_at":           bson.M{"$lt": before},
+	}).Sort("created_at").All(&result)
+	return result, err
+}
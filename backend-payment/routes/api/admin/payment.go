@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"backend-payment/database"
+	"backend-payment/helpers"
+	"backend-payment/middleware"
+	"backend-payment/models"
+)
+
+// SetupAdminPaymentRoutes sets up the admin payment routes
+func SetupAdminPaymentRoutes(r *gin.Engine) {
+	adminGroup := r.Group("/admin")
+	adminGroup.Use(middleware.AuthMiddleware(), middleware.RequirePermission(middleware.PermissionOrdersRead))
+	{
+		adminGroup.GET("/payments", GetAllPayments)
+	}
+}
+
+// paymentSortFields maps the sort keys accepted by /admin/payments to document fields
+var paymentSortFields = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"amount":     "amount",
+}
+
+// GetAllPayments godoc
+// @Summary Get all payments
+// @Description Search the payment transactions of all users with filters and cursor pagination (admin only, requires the orders:read permission)
+// @Tags admin,payments
+// @Produce json
+// @Param status query string false "Comma separated statuses"
+// @Param order_id query string false "Order ID"
+// @Param user_id query string false "User ID"
+// @Param gateway query string false "Payment gateway"
+// @Param from query string false "Created at or after, RFC 3339 or YYYY-MM-DD"
+// @Param to query string false "Created before, RFC 3339 or YYYY-MM-DD"
+// @Param min_amount query number false "Minimum amount"
+// @Param max_amount query number false "Maximum amount"
+// @Param sort query string false "Comma separated sort keys among created_at, updated_at, amount; prefix with - for descending" default(-created_at)
+// @Param limit query int false "Page size, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Security ApiKeyAuth
+// @Success 200 {object} helpers.Page[models.Transaction]
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/payments [get]
+func GetAllPayments(c *gin.Context) {
+	filter, ok := paymentFilterFromQuery(c)
+	if !ok {
+		return
+	}
+
+	keys, err := helpers.ParseSort(c.Query("sort"), paymentSortFields, "-created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := helpers.ParseLimit(c.Query("limit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	collection := database.GetDB().Collection("transactions")
+
+	total, err := collection.Find(ctx, filter).Count()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count transactions"})
+		return
+	}
+
+	pageFilter := filter
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := helpers.CursorFilter(keys, cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pageFilter = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	// Fetch one extra transaction to know whether there is a next page
+	var transactions []models.Transaction
+	err = collection.Find(ctx, pageFilter).Sort(helpers.SortFields(keys)...).Limit(limit + 1).All(&transactions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
+	}
+
+	page := helpers.Page[models.Transaction]{Items: transactions, Total: total}
+	if int64(len(transactions)) > limit {
+		page.Items = transactions[:limit]
+		page.NextCursor, err = helpers.EncodeCursor(keys, page.Items[limit-1])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to paginate transactions"})
+			return
+		}
+	}
+
+	if page.Items == nil {
+		page.Items = []models.Transaction{}
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// paymentFilterFromQuery builds the transaction filter from the query string.
+// It writes a 400 response and returns false when a parameter is invalid.
+func paymentFilterFromQuery(c *gin.Context) (bson.M, bool) {
+	conditions := []bson.M{}
+
+	if status := c.Query("status"); status != "" {
+		conditions = append(conditions, bson.M{"status": bson.M{"$in": strings.Split(status, ",")}})
+	}
+
+	for param, field := range map[string]string{"order_id": "order_id", "user_id": "user_id", "gateway": "gateway"} {
+		if value := c.Query(param); value != "" {
+			conditions = append(conditions, bson.M{field: value})
+		}
+	}
+
+	created := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+		if value := c.Query(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return nil, false
+			}
+			created[op] = t
+		}
+	}
+	if len(created) > 0 {
+		conditions = append(conditions, bson.M{"created_at": created})
+	}
+
+	amount := bson.M{}
+	for param, op := range map[string]string{"min_amount": "$gte", "max_amount": "$lte"} {
+		if value := c.Query(param); value != "" {
+			value, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return nil, false
+			}
+			amount[op] = value
+		}
+	}
+	if len(amount) > 0 {
+		conditions = append(conditions, bson.M{"amount": amount})
+	}
+
+	if len(conditions) == 0 {
+		return bson.M{}, true
+	}
+	return bson.M{"$and": conditions}, true
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
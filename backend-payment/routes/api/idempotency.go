@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend-payment/database"
+	"backend-payment/middleware"
+	"backend-payment/models"
+)
+
+// IdempotencyKeyHeader lets clients retry a request without charging twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// respondIdempotent responds with the result of run. When the request has an
+// Idempotency-Key header, run happens once for the key and repeats get the
+// recorded response.
+func respondIdempotent(c *gin.Context, req interface{}, run func() (int, interface{})) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		c.JSON(run())
+		return
+	}
+	if len(key) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+
+	// Keys are chosen by clients, so each caller has their own
+	key = middleware.CurrentPrincipal(c).UserID + ":" + key
+
+	ctx := context.Background()
+	keys := database.GetDB().Collection("idempotency_keys")
+
+	fingerprint, err := requestFingerprint(c.Request.URL.Path, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return
+	}
+
+	recorded, err := models.ClaimIdempotencyKey(ctx, keys, key, fingerprint)
+	switch {
+	case err == models.ErrIdempotencyKeyReused:
+		c.JSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was already used for a different request"})
+		return
+	case err == models.ErrIdempotencyKeyInProgress:
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return
+	case recorded != nil:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(recorded.StatusCode, "application/json; charset=utf-8", recorded.Response)
+		return
+	}
+
+	status, response := run()
+	body, err := json.Marshal(response)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return
+	}
+
+	// Server errors are worth retrying, so their response isn't kept
+	if status >= http.StatusInternalServerError {
+		err = models.ReleaseIdempotencyKey(ctx, keys, key)
+	} else {
+		err = models.CompleteIdempotencyKey(ctx, keys, key, status, body)
+	}
+	if err != nil {
+		log.Printf("Error recording idempotency key %q: %v", key, err)
+	}
+
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+// requestFingerprint identifies the request an idempotency key is used with.
+// The bound request is fingerprinted, so that formatting doesn't count, with
+// the path, so that a key can't be reused on another endpoint.
+func requestFingerprint(path string, req interface{}) (string, error) {
+	canonical, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(path+"\n"), canonical...))
+	return hex.EncodeToString(sum[:]), nil
+}
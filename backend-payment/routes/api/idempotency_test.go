@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	bind := func(body string) CreateRefundRequest {
+		t.Helper()
+		var req CreateRefundRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	first := bind(`{"amount": 5, "reason": "damaged"}`)
+
+	tests := []struct {
+		name string
+		path string
+		req  interface{}
+		same bool
+	}{
+		{"same request", "/payments/1/refunds", first, true},
+		{"other formatting and field order", "/payments/1/refunds", bind(`{ "reason":"damaged","amount":5.0 }`), true},
+		{"unknown fields", "/payments/1/refunds", bind(`{"amount": 5, "reason": "damaged", "note": "x"}`), true},
+		{"other amount", "/payments/1/refunds", bind(`{"amount": 6, "reason": "damaged"}`), false},
+		{"other path", "/payments/2/refunds", first, false},
+		{"other endpoint with the same body", "/payments", first, false},
+	}
+
+	want, err := requestFingerprint("/payments/1/refunds", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := requestFingerprint(tt.path, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == want) != tt.same {
+				t.Errorf("fingerprint equal = %v, want %v", got == want, tt.same)
+			}
+		})
+	}
+}
+
+func TestRequestFingerprintOfUnencodableRequest(t *testing.T) {
+	if _, err := requestFingerprint("/payments", make(chan int)); err == nil {
+		t.Error("expected an error for a request that can't be encoded")
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"backend-payment/helpers"
+	"backend-payment/models"
+)
+
+// PayableOrder is the amount and state of an order, as recorded by backend-order
+type PayableOrder struct {
+	OrderID  string  `json:"order_id"`
+	UserID   string  `json:"user_id"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Status   string  `json:"status"`
+	Payable  bool    `json:"payable"`
+}
+
+var (
+	errOrderNotFound   = errors.New("order not found")
+	errOrderNotPayable = errors.New("order can't be paid")
+	// errOrderServiceRejected is returned when backend-order refused a
+	// notification, which sending it again won't change
+	errOrderServiceRejected = errors.New("order service rejected the notification")
+)
+
+var orderServiceClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchPayableOrder asks backend-order what the order costs and whether it
+// is waiting for a payment
+func fetchPayableOrder(ctx context.Context, orderID string) (PayableOrder, error) {
+	resp, err := orderServiceRequest(ctx, http.MethodGet, "/backend/orders/"+url.PathEscape(orderID)+"/payable", nil)
+	if err != nil {
+		return PayableOrder{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusBadRequest:
+		return PayableOrder{}, errOrderNotFound
+	default:
+		return PayableOrder{}, fmt.Errorf("order service responded with status code: %d", resp.StatusCode)
+	}
+
+	var order PayableOrder
+	if err := json.NewDecoder(resp.Body).Decode(&order); err != nil {
+		return PayableOrder{}, fmt.Errorf("failed to decode order: %w", err)
+	}
+	return order, nil
+}
+
+// startOrderPayment moves the order to PaymentPending, so that backend-order
+// doesn't expire or cancel it while it is charged
+func startOrderPayment(ctx context.Context, orderID string) error {
+	resp, err := orderServiceRequest(ctx, http.MethodPost, "/backend/orders/"+url.PathEscape(orderID)+"/payment-started", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound, http.StatusBadRequest:
+		return errOrderNotFound
+	case http.StatusConflict:
+		return errOrderNotPayable
+	default:
+		return fmt.Errorf("order service responded with status code: %d", resp.StatusCode)
+	}
+}
+
+// notifyOrderService tells backend-order about the outcome of a settled
+// transaction
+func notifyOrderService(transaction models.Transaction) error {
+	// Refunds are notified on their own, the payment itself was completed
+	status := transaction.Status
+	if status == models.TransactionStatusPartiallyRefunded || status == models.TransactionStatusRefunded {
+		status = models.TransactionStatusCompleted
+	}
+
+	payload := map[string]interface{}{
+		"order_id": transaction.OrderID,
+		"status":   status,
+		"amount":   transaction.Amount,
+		"currency": transaction.Currency,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	resp, err := orderServiceRequest(context.Background(), http.MethodPost, "/backend/payment-update", jsonPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return notificationError(resp.StatusCode)
+}
+
+// notifyOrderRefund tells backend-order about a refund
+func notifyOrderRefund(refund models.Refund) error {
+	jsonPayload, err := json.Marshal(map[string]interface{}{
+		"order_id":       refund.OrderID,
+		"refund_id":      refund.ID.Hex(),
+		"amount":         refund.Amount,
+		"refunded_total": refund.RefundedTotal,
+		"currency":       refund.Currency,
+		"reason":         refund.Reason,
+		"restock":        refund.Restock,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	resp, err := orderServiceRequest(context.Background(), http.MethodPost, "/backend/refund-update", jsonPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return notificationError(resp.StatusCode)
+}
+
+// notificationError returns the error of a notification answered with the
+// status code, errOrderServiceRejected if backend-order refused it
+func notificationError(statusCode int) error {
+	switch statusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict:
+		return fmt.Errorf("%w with status code: %d", errOrderServiceRejected, statusCode)
+	default:
+		return fmt.Errorf("order service responded with status code: %d", statusCode)
+	}
+}
+
+// orderServiceRequest sends a signed request to backend-order
+func orderServiceRequest(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	orderServiceURL := os.Getenv("API_ORDER_URL")
+	if orderServiceURL == "" {
+		return nil, fmt.Errorf("API_ORDER_URL environment variable is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, orderServiceURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Sign the request
+	timestamp := time.Now()
+	signature := helpers.SignRequest(req.Method, req.URL.Path, body, timestamp, "")
+	req.Header.Set(helpers.SignatureHeader, signature)
+	req.Header.Set(helpers.TimestampHeader, timestamp.Format(time.RFC3339))
+
+	resp, err := orderServiceClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to order service: %w", err)
+	}
+	return resp, nil
+}
+
+// sameAmount compares amounts in cents, so that float rounding doesn't matter
+func sameAmount(a, b float64) bool {
+	return helpers.ToCents(a) == helpers.ToCents(b)
+}
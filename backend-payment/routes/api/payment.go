@@ -1,140 +1,456 @@
 package api
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand/v2"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"backend-payment/database"
+	"backend-payment/gateway"
 	"backend-payment/helpers"
+	"backend-payment/middleware"
 	"backend-payment/models"
 )
 
 // SetupPaymentRoutes sets up the payment-related routes
 func SetupPaymentRoutes(r *gin.Engine) {
 	paymentGroup := r.Group("/payments")
+	paymentGroup.Use(middleware.AuthMiddleware())
 	{
-		paymentGroup.POST("", createPaymentHandler)
+		paymentGroup.POST("", middleware.RequireScope(middleware.PermissionOrdersWrite), createPaymentHandler)
+		paymentGroup.GET("", middleware.RequireScope(middleware.PermissionOrdersRead), listOrderPaymentsHandler)
+		paymentGroup.GET("/:id", middleware.RequireScope(middleware.PermissionOrdersRead), getPaymentHandler)
+		paymentGroup.POST("/:id/refunds", middleware.RequirePermission(middleware.PermissionOrdersRefund), createRefundHandler)
+		paymentGroup.GET("/:id/refunds", middleware.RequireScope(middleware.PermissionOrdersRead), listRefundsHandler)
 	}
 }
 
 type CreatePaymentRequest struct {
-	OrderID string  `json:"order_id" binding:"required"`
-	Amount  float64 `json:"amount" binding:"required,gt=0"`
+	OrderID string `json:"order_id" binding:"required"`
+	// Amount is optional: the order total is charged, and a different
+	// amount is refused
+	Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+	// CardToken is the payment method tokenized by the payment gateway
+	CardToken string `json:"card_token"`
 }
 
 // @Summary Create a new payment
-// @Description Create a new payment transaction
+// @Description Create a new payment transaction for the total of one of the caller's orders, as recorded by backend-order, authorized and captured with the payment gateway set by PAYMENT_GATEWAY. The transaction is Pending when the gateway hasn't decided yet or didn't answer in time, poll GET /payments/{id} to follow it. An order has at most one pending or completed transaction.
+// @Description Send a unique Idempotency-Key header to retry safely: repeats of the request with the key get the original response, with an Idempotent-Replayed header, for 24 hours. Reusing a key for a different request, or while the first request runs, gets 409
 // @Tags Payments
 // @Accept json
 // @Produce json
+// @Param Idempotency-Key header string false "Unique key of the payment attempt"
 // @Param payment body CreatePaymentRequest true "Payment details"
+// @Security ApiKeyAuth
 // @Success 201 {object} models.Transaction
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
 // @Router /payments [post]
 func createPaymentHandler(c *gin.Context) {
-	fmt.Println("createPaymentHandler called")
 	var req CreatePaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	principal := middleware.CurrentPrincipal(c)
+	respondIdempotent(c, req, func() (int, interface{}) {
+		return createPayment(c.Request.Context(), principal, req)
+	})
+}
+
+// createPayment charges the order of the request and returns the response
+func createPayment(ctx context.Context, principal helpers.Principal, req CreatePaymentRequest) (int, interface{}) {
+	// The browser can't be trusted with the amount, charge the order total
+	order, err := fetchPayableOrder(ctx, req.OrderID)
+	if err == errOrderNotFound || (err == nil && order.UserID != principal.UserID) {
+		return http.StatusNotFound, gin.H{"error": "Order not found"}
+	}
+	if err != nil {
+		log.Printf("Error fetching order %s: %v", req.OrderID, err)
+		return http.StatusBadGateway, gin.H{"error": "Order service unavailable"}
+	}
+	if !order.Payable {
+		return http.StatusConflict, gin.H{"error": fmt.Sprintf("Order is %s and can't be paid", order.Status)}
+	}
+	if req.Amount != 0 && !sameAmount(req.Amount, order.Amount) {
+		return http.StatusBadRequest, gin.H{"error": "Amount doesn't match the order total"}
+	}
+
+	gw := gateway.Default()
 	transaction := models.Transaction{
-		ID:        primitive.NewObjectID(),
-		OrderID:   req.OrderID,
-		Amount:    req.Amount,
-		Status:    models.TransactionStatusPending,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:            primitive.NewObjectID(),
+		OrderID:       order.OrderID,
+		UserID:        order.UserID,
+		Amount:        order.Amount,
+		Currency:      order.Currency,
+		Status:        models.TransactionStatusPending,
+		Gateway:       gw.Name(),
+		ActiveOrderID: order.OrderID,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
 	}
 
 	db := database.GetDB()
 	collection := db.Collection("transactions")
 
-	_, err := collection.InsertOne(context.Background(), transaction)
+	_, err = collection.InsertOne(context.Background(), transaction)
+	if qmgo.IsDup(err) && settleActivePayment(ctx, collection, order.OrderID) {
+		// The payment in the way turned out to have failed
+		_, err = collection.InsertOne(context.Background(), transaction)
+	}
+	if qmgo.IsDup(err) {
+		return http.StatusConflict, gin.H{"error": "Order already has a pending or completed payment"}
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"})
+		return http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"}
+	}
+
+	if err := startOrderPayment(ctx, order.OrderID); err != nil {
+		// Nothing was charged, drop the transaction so that the order can be paid again
+		if err := collection.RemoveId(context.Background(), transaction.ID); err != nil {
+			log.Printf("Failed to remove transaction %s: %v", transaction.ID.Hex(), err)
+		}
+		switch err {
+		case errOrderNotFound:
+			return http.StatusNotFound, gin.H{"error": "Order not found"}
+		case errOrderNotPayable:
+			return http.StatusConflict, gin.H{"error": "Order can't be paid"}
+		}
+		log.Printf("Error starting payment of order %s: %v", order.OrderID, err)
+		return http.StatusBadGateway, gin.H{"error": "Order service unavailable"}
+	}
+
+	chargePayment(ctx, gw, &transaction, req.CardToken)
+
+	if _, err := recordCharge(context.Background(), collection, &transaction); err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Failed to update transaction status"}
+	}
+
+	return http.StatusCreated, transaction
+}
+
+// chargePayment authorizes and captures the transaction with the gateway, and
+// sets its status from the outcome
+func chargePayment(ctx context.Context, gw gateway.PaymentGateway, transaction *models.Transaction, cardToken string) {
+	result, err := gw.Authorize(ctx, gateway.AuthorizeRequest{
+		OrderID:   transaction.OrderID,
+		Amount:    transaction.Amount,
+		Currency:  transaction.Currency,
+		CardToken: cardToken,
+		// A lost answer can be found by the transaction ID
+		IdempotencyKey: transaction.ID.Hex(),
+	})
+	transaction.GatewayReference = result.Reference
+	switch {
+	case err == gateway.ErrTimeout:
+		// The outcome is unknown, the payment stays pending until checked
+		transaction.Status = models.TransactionStatusPending
+		transaction.FailureReason = "Payment gateway timeout"
+		return
+	case err != nil:
+		log.Printf("Failed to authorize payment of order %s: %v", transaction.OrderID, err)
+		transaction.Status = models.TransactionStatusFailed
+		transaction.FailureReason = "Payment gateway error"
 		return
 	}
 
-	// Mock payment gateway integration
-	if rand.Float32() < 0.8 {
-		// 80% chance of success
+	applyGatewayResult(ctx, gw, transaction, result)
+}
+
+// applyGatewayResult sets the status of the transaction from the state of
+// its payment at the gateway, capturing authorized payments
+func applyGatewayResult(ctx context.Context, gw gateway.PaymentGateway, transaction *models.Transaction, result gateway.Result) {
+	switch result.Status {
+	case gateway.StatusPending:
+		transaction.Status = models.TransactionStatusPending
+		return
+	case gateway.StatusCaptured:
 		transaction.Status = models.TransactionStatusCompleted
-	} else {
-		// 20% chance of failure
+		transaction.FailureReason = ""
+		return
+	case gateway.StatusAuthorized:
+	default:
+		transaction.Status = models.TransactionStatusFailed
+		transaction.FailureReason = result.Message
+		if transaction.FailureReason == "" {
+			transaction.FailureReason = "Payment " + result.Status
+		}
+		return
+	}
+
+	result, err := gw.Capture(ctx, transaction.GatewayReference, transaction.Amount)
+	if err != nil || result.Status != gateway.StatusCaptured {
+		log.Printf("Failed to capture payment %s of order %s: %v", transaction.GatewayReference, transaction.OrderID, err)
+		// Release the funds rather than leaving them held
+		if _, err := gw.Void(ctx, transaction.GatewayReference); err != nil {
+			log.Printf("Failed to void payment %s: %v", transaction.GatewayReference, err)
+		}
 		transaction.Status = models.TransactionStatusFailed
+		transaction.FailureReason = "Payment capture failed"
+		return
 	}
+	transaction.Status = models.TransactionStatusCompleted
+	transaction.FailureReason = ""
+}
+
+// recordCharge stores the outcome of the charge of a pending transaction,
+// and notifies backend-order once it is settled. It returns false when the
+// transaction was settled by another request first.
+func recordCharge(ctx context.Context, transactions *qmgo.Collection, transaction *models.Transaction) (bool, error) {
 	transaction.UpdatedAt = time.Now()
 
-	err = collection.UpdateOne(
-		context.Background(),
-		primitive.M{"_id": transaction.ID},
-		primitive.M{"$set": primitive.M{"status": transaction.Status, "updated_at": transaction.UpdatedAt}},
-	)
+	update := primitive.M{"$set": primitive.M{
+		"status":            transaction.Status,
+		"gateway_reference": transaction.GatewayReference,
+		"failure_reason":    transaction.FailureReason,
+		"updated_at":        transaction.UpdatedAt,
+	}}
+	if transaction.Status == models.TransactionStatusFailed {
+		// The order can be paid again
+		transaction.ActiveOrderID = ""
+		update["$unset"] = primitive.M{"active_order_id": ""}
+	}
+	err := transactions.UpdateOne(ctx, primitive.M{
+		"_id":    transaction.ID,
+		"status": models.TransactionStatusPending,
+	}, update)
+	if err == qmgo.ErrNoSuchDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The order waits for pending payments to be settled
+	if transaction.Status != models.TransactionStatusPending {
+		notifyPayment(ctx, transactions, transaction)
+	}
+	return true, nil
+}
+
+// notifyPayment tells backend-order about the outcome of a settled
+// transaction. Outcomes it didn't record are sent again by
+// NotifyPendingPayments, unless it refused them.
+func notifyPayment(ctx context.Context, transactions *qmgo.Collection, transaction *models.Transaction) {
+	err := notifyOrderService(*transaction)
+	if errors.Is(err, errOrderServiceRejected) {
+		log.Printf("Order service refused payment %s of order %s, needs checking: %v", transaction.ID.Hex(), transaction.OrderID, err)
+		transaction.NotificationFailure = err.Error()
+		if err := models.MarkTransactionNotificationFailed(ctx, transactions, transaction.ID, transaction.NotificationFailure); err != nil {
+			log.Printf("Error recording notification failure of payment %s: %v", transaction.ID.Hex(), err)
+		}
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction status"})
+		log.Printf("Error notifying order service of payment %s: %v", transaction.ID.Hex(), err)
+		return
+	}
+	if err := models.MarkTransactionNotified(ctx, transactions, transaction.ID); err != nil {
+		log.Printf("Error recording notification of payment %s: %v", transaction.ID.Hex(), err)
 		return
 	}
+	transaction.OrderNotified = true
+}
+
+// NotifyPendingPayments sends backend-order the outcomes of settled
+// transactions it hasn't recorded yet, so that their orders don't stay
+// PaymentPending
+func NotifyPendingPayments() {
+	ctx := context.Background()
+	transactions := database.GetDB().Collection("transactions")
 
-	// After updating the transaction status
-	if err = notifyOrderService(transaction); err != nil {
-		log.Printf("Error notifying order service: %v", err)
+	// Leave the transactions being settled right now alone
+	pending, err := models.ListUnnotifiedTransactions(ctx, transactions, time.Now().Add(-time.Minute))
+	if err != nil {
+		log.Printf("Error fetching payments to notify: %v", err)
+		return
+	}
+	for i := range pending {
+		notifyPayment(ctx, transactions, &pending[i])
+	}
+}
+
+// SettlePendingPayments settles the pending transactions the gateway may have
+// decided, so that their orders don't wait for a client to poll them
+func SettlePendingPayments() {
+	ctx := context.Background()
+	transactions := database.GetDB().Collection("transactions")
+
+	// Leave the transactions being charged right now alone
+	filter := primitive.M{
+		"status":     models.TransactionStatusPending,
+		"created_at": primitive.M{"$lt": time.Now().Add(-time.Minute)},
 	}
+	var pending []models.Transaction
+	if err := transactions.Find(ctx, filter).All(&pending); err != nil {
+		log.Printf("Error fetching pending payments: %v", err)
+		return
+	}
+
+	for i := range pending {
+		settlePendingPayment(ctx, transactions, &pending[i])
+	}
+}
 
-	c.JSON(http.StatusCreated, transaction)
+// settleActivePayment settles the pending transaction holding the order, and
+// tells whether the order is free to be paid again
+func settleActivePayment(ctx context.Context, transactions *qmgo.Collection, orderID string) bool {
+	var transaction models.Transaction
+	if err := transactions.Find(ctx, primitive.M{"active_order_id": orderID}).One(&transaction); err != nil {
+		return err == qmgo.ErrNoSuchDocuments
+	}
+	settlePendingPayment(ctx, transactions, &transaction)
+	return transaction.Status == models.TransactionStatusFailed
 }
 
-func notifyOrderService(transaction models.Transaction) error {
-	payload := map[string]interface{}{
-		"order_id": transaction.OrderID,
-		"status":   transaction.Status,
-		"amount":   transaction.Amount,
+// settlePendingPayment asks the gateway whether a pending payment was decided
+// and records the outcome. Payments the gateway has no trace of fail once
+// PendingPaymentTimeout has passed, so that the order can be paid again.
+func settlePendingPayment(ctx context.Context, transactions *qmgo.Collection, transaction *models.Transaction) {
+	if transaction.Status != models.TransactionStatusPending {
+		return
 	}
 
-	jsonPayload, err := json.Marshal(payload)
+	gw, err := gateway.Get(transaction.Gateway)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		log.Printf("Can't check payment %s: %v", transaction.ID.Hex(), err)
+		return
+	}
+
+	// Without a reference, the answer to the authorization was lost
+	var result gateway.Result
+	if transaction.GatewayReference != "" {
+		result, err = gw.Status(ctx, transaction.GatewayReference)
+	} else {
+		result, err = gw.Lookup(ctx, transaction.ID.Hex())
 	}
 
-	orderServiceURL := os.Getenv("API_ORDER_URL")
-	if orderServiceURL == "" {
-		return fmt.Errorf("API_ORDER_URL environment variable is not set")
+	settled := *transaction
+	switch {
+	case err == gateway.ErrUnknownPayment:
+		if time.Since(transaction.CreatedAt) < models.PendingPaymentTimeout {
+			return
+		}
+		settled.Status = models.TransactionStatusFailed
+		settled.FailureReason = "Payment not received by the payment gateway"
+	case err != nil:
+		log.Printf("Failed to check payment %s: %v", transaction.ID.Hex(), err)
+		return
+	default:
+		settled.GatewayReference = result.Reference
+		applyGatewayResult(ctx, gw, &settled, result)
+		if settled.Status == models.TransactionStatusPending {
+			return
+		}
 	}
 
-	req, err := http.NewRequest("POST", orderServiceURL+"/backend/payment-update", bytes.NewBuffer(jsonPayload))
+	recorded, err := recordCharge(ctx, transactions, &settled)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		log.Printf("Failed to record payment %s: %v", transaction.ID.Hex(), err)
+		return
+	}
+	if !recorded {
+		// Another request settled it, show what it recorded
+		if err := transactions.Find(ctx, primitive.M{"_id": transaction.ID}).One(&settled); err != nil {
+			return
+		}
 	}
+	*transaction = settled
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// findAccessiblePayment returns the transaction of the id parameter if it
+// belongs to the caller, or the caller may read all orders. It writes an
+// error response and returns false otherwise.
+func findAccessiblePayment(c *gin.Context) (models.Transaction, bool) {
+	transactionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return models.Transaction{}, false
+	}
 
-	// Sign the request
-	timestamp := time.Now()
-	signature := helpers.SignRequest(req.Method, req.URL.Path, jsonPayload, timestamp)
-	req.Header.Set(helpers.SignatureHeader, signature)
-	req.Header.Set(helpers.TimestampHeader, timestamp.Format(time.RFC3339))
+	var transaction models.Transaction
+	err = database.GetDB().Collection("transactions").Find(c.Request.Context(), primitive.M{"_id": transactionID}).One(&transaction)
+	if err != nil && err != qmgo.ErrNoSuchDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transaction"})
+		return models.Transaction{}, false
+	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request to order service: %w", err)
+	principal := middleware.CurrentPrincipal(c)
+	// Payments of others are reported missing rather than forbidden
+	if err == qmgo.ErrNoSuchDocuments ||
+		(transaction.UserID != principal.UserID && !principal.HasPermission(middleware.PermissionOrdersRead)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return models.Transaction{}, false
+	}
+	return transaction, true
+}
+
+// @Summary Get a payment
+// @Description Get a payment transaction of the caller, or of anyone with the orders:read permission. A Pending transaction is checked with the payment gateway first, so clients can poll it until it is Completed or Failed. Pending transactions the gateway never received fail after 15 minutes
+// @Tags Payments
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.Transaction
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /payments/{id} [get]
+func getPaymentHandler(c *gin.Context) {
+	transaction, ok := findAccessiblePayment(c)
+	if !ok {
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("order service responded with status code: %d", resp.StatusCode)
+	settlePendingPayment(c.Request.Context(), database.GetDB().Collection("transactions"), &transaction)
+
+	c.JSON(http.StatusOK, transaction)
+}
+
+// @Summary List the payments of an order
+// @Description List the payment transactions of one of the caller's orders, or of any order with the orders:read permission, newest first
+// @Tags Payments
+// @Produce json
+// @Param order_id query string true "Order ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} models.Transaction
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /payments [get]
+func listOrderPaymentsHandler(c *gin.Context) {
+	orderID := c.Query("order_id")
+	if orderID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order_id is required"})
+		return
+	}
+
+	filter := primitive.M{"order_id": orderID}
+	if principal := middleware.CurrentPrincipal(c); !principal.HasPermission(middleware.PermissionOrdersRead) {
+		filter["user_id"] = principal.UserID
+	}
+
+	transactions := []models.Transaction{}
+	err := database.GetDB().Collection("transactions").Find(c.Request.Context(), filter).Sort("-created_at").All(&transactions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
 	}
 
-	return nil
+	c.JSON(http.StatusOK, transactions)
 }
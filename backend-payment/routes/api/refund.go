@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend-payment/database"
+	"backend-payment/gateway"
+	"backend-payment/models"
+)
+
+type CreateRefundRequest struct {
+	// Amount defaults to what is left to refund
+	Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+	Reason string  `json:"reason" binding:"max=500"`
+	// Restock puts the order items back in stock, only with a refund of
+	// everything left to refund
+	Restock bool `json:"restock"`
+}
+
+// @Summary Refund a payment
+// @Description Refund part or all of a completed payment through the payment gateway that charged it, with the orders:refund permission. Refunds of a payment can't exceed the captured amount. backend-order is notified, and moves the order to PartiallyRefunded or Refunded; notifications it misses are sent again in the background. A refund the gateway didn't answer in time is Pending, with 202, and keeps its amount reserved until it is sent again in the background. Send an Idempotency-Key header to retry safely
+// @Tags Payments
+// @Accept json
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Param Idempotency-Key header string false "Unique key of the refund attempt"
+// @Param refund body CreateRefundRequest true "Refund details"
+// @Security ApiKeyAuth
+// @Success 201 {object} models.Refund
+// @Success 202 {object} models.Refund
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /payments/{id}/refunds [post]
+func createRefundHandler(c *gin.Context) {
+	transactionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var req CreateRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondIdempotent(c, req, func() (int, interface{}) {
+		return createRefund(c.Request.Context(), transactionID, req)
+	})
+}
+
+// createRefund refunds the transaction and returns the response
+func createRefund(ctx context.Context, transactionID primitive.ObjectID, req CreateRefundRequest) (int, interface{}) {
+	db := database.GetDB()
+	transactions := db.Collection("transactions")
+
+	var transaction models.Transaction
+	err := transactions.Find(ctx, primitive.M{"_id": transactionID}).One(&transaction)
+	if err == qmgo.ErrNoSuchDocuments {
+		return http.StatusNotFound, gin.H{"error": "Transaction not found"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Failed to fetch transaction"}
+	}
+
+	refundable := transaction.RefundableAmount()
+	if refundable <= 0 {
+		return http.StatusConflict, gin.H{"error": fmt.Sprintf("A %s payment can't be refunded", transaction.Status)}
+	}
+	amount := req.Amount
+	if amount == 0 {
+		amount = refundable
+	}
+	if !sameAmount(amount, refundable) {
+		if amount > refundable {
+			return http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Refund exceeds the %.2f left to refund", refundable)}
+		}
+		if req.Restock {
+			return http.StatusBadRequest, gin.H{"error": "Restocking requires refunding everything left to refund"}
+		}
+	}
+
+	transaction, err = models.ReserveRefund(ctx, transactions, transactionID, amount)
+	if err == models.ErrRefundExceedsCaptured {
+		return http.StatusConflict, gin.H{"error": "Refund exceeds the amount left to refund"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Failed to refund payment"}
+	}
+
+	refunds := db.Collection("refunds")
+	refund := models.Refund{
+		ID:            primitive.NewObjectID(),
+		TransactionID: transaction.ID,
+		OrderID:       transaction.OrderID,
+		Amount:        amount,
+		Currency:      transaction.Currency,
+		Reason:        req.Reason,
+		Restock:       req.Restock,
+		Status:        models.RefundStatusPending,
+		RefundedTotal: transaction.RefundedAmount,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	// Record the refund before any money moves, so that it can't be lost
+	if _, err := refunds.InsertOne(ctx, refund); err != nil {
+		log.Printf("Error recording refund of payment %s: %v", transaction.ID.Hex(), err)
+		if err := models.ReleaseRefund(ctx, transactions, transaction.ID, amount); err != nil {
+			log.Printf("Error releasing refund of payment %s: %v", transaction.ID.Hex(), err)
+		}
+		return http.StatusInternalServerError, gin.H{"error": "Failed to refund payment"}
+	}
+
+	result, err := refundWithGateway(ctx, transaction, amount, refund.ID.Hex())
+	finishRefund(ctx, transactions, refunds, transaction, &refund, result, err)
+
+	// From here on the response must not be 5xx, a retry could refund twice
+	switch refund.Status {
+	case models.RefundStatusPending:
+		return http.StatusAccepted, refund
+	case models.RefundStatusFailed:
+		return http.StatusBadGateway, gin.H{"error": refund.FailureReason}
+	}
+	return http.StatusCreated, refund
+}
+
+// finishRefund records the answer of the gateway to a pending refund. A
+// failed refund gives back its reserved amount, a completed one settles the
+// transaction, from the refunded amount it had once the refund was reserved,
+// and is sent to backend-order. Refunds the gateway didn't answer stay
+// pending.
+func finishRefund(ctx context.Context, transactions, refunds *qmgo.Collection, transaction models.Transaction, refund *models.Refund, result gateway.Result, err error) {
+	settled := *refund
+	settled.GatewayReference = result.Reference
+	switch {
+	case err == gateway.ErrTimeout:
+		// The refund may have been made, its amount stays reserved
+		settled.FailureReason = "Payment gateway timeout"
+	case err != nil:
+		log.Printf("Failed to refund payment %s: %v", transaction.ID.Hex(), err)
+		settled.Status = models.RefundStatusFailed
+		settled.FailureReason = "Payment gateway refused the refund"
+	default:
+		settled.Status = models.RefundStatusCompleted
+		settled.FailureReason = ""
+	}
+
+	err = models.RecordRefundOutcome(ctx, refunds, &settled)
+	if err == qmgo.ErrNoSuchDocuments {
+		// Another request settled it, show what it recorded
+		if err := refunds.Find(ctx, primitive.M{"_id": refund.ID}).One(refund); err != nil {
+			log.Printf("Error fetching refund %s: %v", refund.ID.Hex(), err)
+		}
+		return
+	}
+	if err != nil {
+		// Left pending, SettlePendingRefunds sends it again
+		log.Printf("Error recording outcome of refund %s: %v", refund.ID.Hex(), err)
+		return
+	}
+	*refund = settled
+
+	switch refund.Status {
+	case models.RefundStatusFailed:
+		if err := models.ReleaseRefund(ctx, transactions, transaction.ID, refund.Amount); err != nil {
+			log.Printf("Error releasing refund of payment %s: %v", transaction.ID.Hex(), err)
+		}
+	case models.RefundStatusCompleted:
+		if err := models.SettleRefund(ctx, transactions, transaction); err != nil {
+			log.Printf("Error updating status of payment %s: %v", transaction.ID.Hex(), err)
+		}
+		notifyRefund(ctx, refunds, refund)
+	}
+}
+
+// SettlePendingRefunds sends the refunds the gateway didn't answer again,
+// with the same idempotency key so that none is made twice, and completes or
+// releases them from the answer
+func SettlePendingRefunds() {
+	ctx := context.Background()
+	db := database.GetDB()
+	transactions := db.Collection("transactions")
+	refunds := db.Collection("refunds")
+
+	// Leave the refunds being sent right now alone
+	pending, err := models.ListPendingRefunds(ctx, refunds, time.Now().Add(-time.Minute))
+	if err != nil {
+		log.Printf("Error fetching pending refunds: %v", err)
+		return
+	}
+	for i := range pending {
+		refund := &pending[i]
+		var transaction models.Transaction
+		if err := transactions.Find(ctx, primitive.M{"_id": refund.TransactionID}).One(&transaction); err != nil {
+			log.Printf("Error fetching payment of refund %s: %v", refund.ID.Hex(), err)
+			continue
+		}
+		transaction.RefundedAmount = refund.RefundedTotal
+
+		result, err := refundWithGateway(ctx, transaction, refund.Amount, refund.ID.Hex())
+		finishRefund(ctx, transactions, refunds, transaction, refund, result, err)
+	}
+}
+
+// notifyRefund tells backend-order about a completed refund. Refunds it
+// didn't record are sent again by NotifyPendingRefunds, unless it refused
+// them.
+func notifyRefund(ctx context.Context, refunds *qmgo.Collection, refund *models.Refund) {
+	err := notifyOrderRefund(*refund)
+	if errors.Is(err, errOrderServiceRejected) {
+		log.Printf("Order service refused refund %s of order %s, needs checking: %v", refund.ID.Hex(), refund.OrderID, err)
+		refund.NotificationFailure = err.Error()
+		if err := models.MarkRefundNotificationFailed(ctx, refunds, refund.ID, refund.NotificationFailure); err != nil {
+			log.Printf("Error recording notification failure of refund %s: %v", refund.ID.Hex(), err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error notifying order service of refund %s: %v", refund.ID.Hex(), err)
+		return
+	}
+	if err := models.MarkRefundNotified(ctx, refunds, refund.ID); err != nil {
+		log.Printf("Error recording notification of refund %s: %v", refund.ID.Hex(), err)
+		return
+	}
+	refund.OrderNotified = true
+}
+
+// NotifyPendingRefunds sends backend-order the completed refunds it hasn't
+// recorded yet
+func NotifyPendingRefunds() {
+	ctx := context.Background()
+	refunds := database.GetDB().Collection("refunds")
+
+	pending, err := models.ListUnnotifiedRefunds(ctx, refunds)
+	if err != nil {
+		log.Printf("Error fetching refunds to notify: %v", err)
+		return
+	}
+	for i := range pending {
+		notifyRefund(ctx, refunds, &pending[i])
+	}
+}
+
+// refundWithGateway refunds the amount with the provider that charged the
+// transaction. The key makes sending the refund again safe.
+func refundWithGateway(ctx context.Context, transaction models.Transaction, amount float64, idempotencyKey string) (gateway.Result, error) {
+	gw, err := gateway.Get(transaction.Gateway)
+	if err != nil {
+		return gateway.Result{}, err
+	}
+	return gw.Refund(ctx, transaction.GatewayReference, amount, idempotencyKey)
+}
+
+// @Summary List the refunds of a payment
+// @Description List the refunds of a payment of the caller, or of anyone with the orders:read permission, failed ones included, oldest first
+// @Tags Payments
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} models.Refund
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /payments/{id}/refunds [get]
+func listRefundsHandler(c *gin.Context) {
+	transaction, ok := findAccessiblePayment(c)
+	if !ok {
+		return
+	}
+
+	refunds, err := models.ListRefunds(c.Request.Context(), database.GetDB().Collection("refunds"), transaction.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"backend-payment/routes/api"
+	"backend-payment/routes/api/admin"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -14,13 +15,14 @@ func SetupRoutes(r *gin.Engine) {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	api.SetupPaymentRoutes(r)
+	admin.SetupAdminPaymentRoutes(r)
 
 	r.GET("/health", healthCheckHandler)
 }
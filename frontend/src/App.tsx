@@ -4,6 +4,8 @@ import Login from './pages/login/Login';
 import Register from './pages/register/Register'; 
 import ForgotPassword from './pages/forgot-password';
 import ResetPassword from './pages/reset-password';
+import VerifyEmail from './pages/verify-email';
+import SSOCallback from './pages/sso-callback';
 import Products from './pages/products/Products';
 import Orders from './pages/orders/Orders';
 import { AuthProvider, useAuth } from './contexts/AuthContext';
@@ -38,6 +40,8 @@ function AppContent() {
             <Route path="/register" element={<Register />} />
             <Route path="/forgot-password" element={<ForgotPassword />} />
             <Route path="/reset-password" element={<ResetPassword />} /> {/* Add this line */}
+            <Route path="/verify-email" element={<VerifyEmail />} />
+            <Route path="/sso/callback" element={<SSOCallback />} />
             <Route path="/products" element={<ProtectedRoute element={<Products />} />} />
             <Route path="/orders" element={<ProtectedRoute element={<Orders />} />} />
             <Route path="/" element={<Navigate to="/products" replace />} />
@@ -1,7 +1,15 @@
 import { API_ORDER_URL } from './config';
 
-interface LoginResponse {
+export interface LoginResponse {
   token: string;
+  refresh_token: string;
+  expires_in: number;
+  mfa_enrollment_required?: boolean;
+}
+
+export interface MFAChallengeResponse {
+  mfa_required: true;
+  mfa_token: string;
 }
 
 interface LoginRequest {
@@ -9,7 +17,7 @@ interface LoginRequest {
   password: string;
 }
 
-export const loginUser = async (credentials: LoginRequest): Promise<string> => {
+export const loginUser = async (credentials: LoginRequest): Promise<LoginResponse | MFAChallengeResponse> => {
   const response = await fetch(`${API_ORDER_URL}/auth/login`, {
     method: 'POST',
     headers: {
@@ -18,12 +26,169 @@ export const loginUser = async (credentials: LoginRequest): Promise<string> => {
     body: JSON.stringify(credentials),
   });
 
+  if (response.status === 429) {
+    throw new Error('Too many failed login attempts. Please try again later.');
+  }
   if (!response.ok) {
     throw new Error('Login failed');
   }
 
-  const data: LoginResponse = await response.json();
-  return data.token;
+  return response.json();
+};
+
+export const loginMFA = async (mfaToken: string, code: string): Promise<LoginResponse> => {
+  const response = await fetch(`${API_ORDER_URL}/auth/login/mfa`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ mfa_token: mfaToken, code }),
+  });
+
+  if (response.status === 429) {
+    throw new Error('Too many failed login attempts. Please try again later.');
+  }
+  if (!response.ok) {
+    throw new Error('Invalid two-factor code');
+  }
+
+  return response.json();
+};
+
+interface OIDCAuthorizeResponse {
+  authorization_url: string;
+  state: string;
+}
+
+// startSSO returns the identity provider page to redirect to. The state is
+// kept to check that the callback belongs to a login started here.
+export const startSSO = async (): Promise<string> => {
+  const response = await fetch(`${API_ORDER_URL}/auth/oidc/authorize`, { method: 'POST' });
+  if (!response.ok) {
+    throw new Error('Single sign-on is unavailable');
+  }
+
+  const data: OIDCAuthorizeResponse = await response.json();
+  sessionStorage.setItem('ssoState', data.state);
+  return data.authorization_url;
+};
+
+export const completeSSO = async (code: string, state: string): Promise<LoginResponse | MFAChallengeResponse> => {
+  const expectedState = sessionStorage.getItem('ssoState');
+  sessionStorage.removeItem('ssoState');
+  if (!expectedState || expectedState !== state) {
+    throw new Error('Single sign-on expired, please try again');
+  }
+
+  const response = await fetch(`${API_ORDER_URL}/auth/oidc/callback`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ code, state }),
+  });
+
+  if (!response.ok) {
+    const data = await response.json().catch(() => ({}));
+    throw new Error(data.error || 'Single sign-on failed');
+  }
+
+  return response.json();
+};
+
+// emailFromToken reads the email claim of an access token, for logins where
+// the user didn't type it
+export const emailFromToken = (token: string): string => {
+  try {
+    const payload = token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+    return JSON.parse(atob(payload)).email || '';
+  } catch {
+    return '';
+  }
+};
+
+export const storeTokens = (tokens: LoginResponse) => {
+  localStorage.setItem('token', tokens.token);
+  localStorage.setItem('refreshToken', tokens.refresh_token);
+};
+
+export const clearTokens = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refreshToken');
+};
+
+// Concurrent requests failing with an expired token share one refresh, since
+// a refresh token can only be used once
+let pendingRefresh: Promise<string | null> | null = null;
+
+export const refreshAccessToken = (): Promise<string | null> => {
+  if (!pendingRefresh) {
+    pendingRefresh = (async () => {
+      const refreshToken = localStorage.getItem('refreshToken');
+      if (!refreshToken) {
+        return null;
+      }
+
+      const response = await fetch(`${API_ORDER_URL}/auth/refresh`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      });
+
+      if (!response.ok) {
+        clearTokens();
+        return null;
+      }
+
+      const tokens: LoginResponse = await response.json();
+      storeTokens(tokens);
+      return tokens.token;
+    })().finally(() => {
+      pendingRefresh = null;
+    });
+  }
+  return pendingRefresh;
+};
+
+// authFetch sends an authenticated request, refreshing the access token once
+// when it has expired
+export const authFetch = async (url: string, init: RequestInit = {}): Promise<Response> => {
+  const token = localStorage.getItem('token');
+  if (!token) {
+    throw new Error('No authentication token found');
+  }
+
+  const send = (accessToken: string) =>
+    fetch(url, { ...init, headers: { ...init.headers, 'Authorization': accessToken } });
+
+  const response = await send(token);
+  if (response.status !== 401) {
+    return response;
+  }
+
+  const refreshed = await refreshAccessToken();
+  if (!refreshed) {
+    return response;
+  }
+  return send(refreshed);
+};
+
+export const logoutUser = async (): Promise<void> => {
+  const refreshToken = localStorage.getItem('refreshToken');
+  clearTokens();
+  if (!refreshToken) {
+    return;
+  }
+
+  await fetch(`${API_ORDER_URL}/auth/logout`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  });
 };
 
 interface RegisterRequest {
@@ -45,6 +210,34 @@ export const registerUser = async (credentials: RegisterRequest): Promise<void>
   }
 };
 
+export const verifyEmail = async (token: string): Promise<void> => {
+  const response = await fetch(`${API_ORDER_URL}/auth/verify-email`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ token }),
+  });
+
+  if (!response.ok) {
+    throw new Error('Failed to verify email');
+  }
+};
+
+export const resendVerification = async (email: string): Promise<void> => {
+  const response = await fetch(`${API_ORDER_URL}/auth/resend-verification`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ email }),
+  });
+
+  if (!response.ok) {
+    throw new Error('Failed to resend verification email');
+  }
+};
+
 export const forgotPassword = async (email: string): Promise<void> => {
   const response = await fetch(`${API_ORDER_URL}/auth/forgot-password`, {
     method: 'POST',
@@ -59,13 +252,13 @@ export const forgotPassword = async (email: string): Promise<void> => {
   }
 };
 
-export const resetPassword = async (email: string, resetToken: string, newPassword: string): Promise<void> => {
+export const resetPassword = async (resetToken: string, newPassword: string): Promise<void> => {
   const response = await fetch(`${API_ORDER_URL}/auth/reset-password`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
     },
-    body: JSON.stringify({ email, resetToken, newPassword }),
+    body: JSON.stringify({ resetToken, newPassword }),
   });
 
   if (!response.ok) {
@@ -1,4 +1,5 @@
 import { API_ORDER_URL, API_PAYMENT_URL } from './config';
+import { authFetch } from './Auth';
 
 export interface OrderProduct {
   id: string;
@@ -6,45 +7,69 @@ export interface OrderProduct {
   price: number;
 }
 
+export interface OrderItem {
+  product: OrderProduct;
+  quantity: number;
+  subtotal: number;
+}
+
+export type OrderStatus =
+  | 'Created'
+  | 'PaymentPending'
+  | 'Confirmed'
+  | 'Shipped'
+  | 'Delivered'
+  | 'Cancelled'
+  | 'Refunded'
+  | 'Failed';
+
+export type OrderRefundStatus = 'PartiallyRefunded' | 'Refunded';
+
 export interface TimelineEvent {
   name: string;
   timestamp: string;
+  actor?: string;
+  reason?: string;
 }
 
 export interface Order {
   id: string;
+  user_id: string;
   customer_id: string;
-  product: OrderProduct;
-  quantity: number;
+  items: OrderItem[];
   total_amount: number;
-  status: 'Created' | 'Confirmed' | 'Delivered' | 'Cancelled';
+  currency?: string;
+  paid_amount?: number;
+  refunded_amount?: number;
+  refund_status?: OrderRefundStatus;
+  status: OrderStatus;
   payment_id?: string;
   created_at: string;
   updated_at: string;
   timeline: TimelineEvent[];
 }
 
-export interface CreateOrderRequest {
-  customer_id: string;
+export interface OrderItemRequest {
   product_id: string;
   quantity: number;
 }
 
-export const createOrder = async (orderData: CreateOrderRequest): Promise<Order> => {
-  const token = localStorage.getItem('token');
-  if (!token) {
-    throw new Error('No authentication token found');
-  }
+export interface CreateOrderRequest {
+  items: OrderItemRequest[];
+}
 
-  const response = await fetch(`${API_ORDER_URL}/orders`, {
+export const createOrder = async (orderData: CreateOrderRequest): Promise<Order> => {
+  const response = await authFetch(`${API_ORDER_URL}/orders`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': token,
     },
     body: JSON.stringify(orderData),
   });
 
+  if (response.status === 403) {
+    throw new Error('Please verify your email address before placing orders.');
+  }
   if (!response.ok) {
     throw new Error('Failed to create order');
   }
@@ -54,16 +79,7 @@ export const createOrder = async (orderData: CreateOrderRequest): Promise<Order>
 };
 
 export const getOrders = async (): Promise<Order[]> => {
-  const token = localStorage.getItem('token');
-  if (!token) {
-    throw new Error('No authentication token found');
-  }
-
-  const response = await fetch(`${API_ORDER_URL}/orders`, {
-    headers: {
-      'Authorization': token,
-    },
-  });
+  const response = await authFetch(`${API_ORDER_URL}/orders`);
 
   if (!response.ok) {
     throw new Error('Failed to fetch orders');
@@ -72,35 +88,40 @@ export const getOrders = async (): Promise<Order[]> => {
   return response.json();
 };
 
-export const cancelOrder = async (orderId: string): Promise<void> => {
-  const token = localStorage.getItem('token');
-  if (!token) {
-    throw new Error('No authentication token found');
-  }
-
-  const response = await fetch(`${API_ORDER_URL}/orders/${orderId}/cancel`, {
+export const cancelOrder = async (orderId: string): Promise<Order> => {
+  const response = await authFetch(`${API_ORDER_URL}/orders/${orderId}/cancel`, {
     method: 'POST',
-    headers: {
-      'Authorization': token,
-    },
   });
 
   if (!response.ok) {
     throw new Error('Failed to cancel order');
   }
+
+  const data = await response.json();
+  return data.order;
 };
 
-export const initiatePayment = async (orderId: string, amount: number): Promise<void> => {
-  const token = localStorage.getItem('token');
-  if (!token) {
-    throw new Error('No authentication token found');
-  }
+export type PaymentStatus = 'Pending' | 'Completed' | 'Failed' | 'PartiallyRefunded' | 'Refunded';
 
-  const response = await fetch(`${API_PAYMENT_URL}/payments`, {
+export interface Payment {
+  id: string;
+  order_id: string;
+  amount: number;
+  currency: string;
+  status: PaymentStatus;
+  failure_reason?: string;
+  refunded_amount: number;
+  created_at: string;
+  updated_at: string;
+}
+
+// Retrying with the same idempotency key never charges twice
+export const initiatePayment = async (orderId: string, amount: number, idempotencyKey: string): Promise<Payment> => {
+  const response = await authFetch(`${API_PAYMENT_URL}/payments`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': token,
+      'Idempotency-Key': idempotencyKey,
     },
     body: JSON.stringify({ order_id: orderId, amount }),
   });
@@ -108,4 +129,17 @@ export const initiatePayment = async (orderId: string, amount: number): Promise<
   if (!response.ok) {
     throw new Error('Failed to initiate payment');
   }
+
+  return response.json();
+};
+
+// A pending payment is checked with the payment gateway on each call
+export const getPayment = async (paymentId: string): Promise<Payment> => {
+  const response = await authFetch(`${API_PAYMENT_URL}/payments/${paymentId}`);
+
+  if (!response.ok) {
+    throw new Error('Failed to fetch payment');
+  }
+
+  return response.json();
 };
@@ -7,10 +7,38 @@ interface Product {
   stocks: number;
 }
 
-export const fetchProducts = async (): Promise<Product[]> => {
-  const response = await fetch(`${API_ORDER_URL}/products`);
+export interface ProductPage {
+  items: Product[];
+  next_cursor?: string;
+  total: number;
+}
+
+export interface ProductQuery {
+  q?: string;
+  min_price?: number;
+  max_price?: number;
+  in_stock?: boolean;
+  sort?: string;
+  limit?: number;
+  cursor?: string;
+}
+
+export const searchProducts = async (query: ProductQuery = {}): Promise<ProductPage> => {
+  const params = new URLSearchParams();
+  Object.entries(query).forEach(([key, value]) => {
+    if (value !== undefined && value !== '') {
+      params.set(key, String(value));
+    }
+  });
+
+  const response = await fetch(`${API_ORDER_URL}/products?${params.toString()}`);
   if (!response.ok) {
     throw new Error('Failed to fetch products');
   }
   return response.json();
 };
+
+export const fetchProducts = async (): Promise<Product[]> => {
+  const page = await searchProducts({ limit: 100 });
+  return page.items;
+};
@@ -1,9 +1,10 @@
 import React, { createContext, useState, useContext, ReactNode, useEffect } from 'react';
+import { LoginResponse, logoutUser, storeTokens } from '../api/Auth';
 
 interface AuthContextType {
   isAuthenticated: boolean;
   userEmail: string | null;
-  login: (token: string, email: string) => void;
+  login: (tokens: LoginResponse, email: string) => void;
   logout: () => void;
 }
 
@@ -22,15 +23,15 @@ export const AuthProvider: React.FC<{ children: ReactNode }> = ({ children }) =>
     }
   }, []);
 
-  const login = (token: string, email: string) => {
-    localStorage.setItem('token', token);
+  const login = (tokens: LoginResponse, email: string) => {
+    storeTokens(tokens);
     localStorage.setItem('userEmail', email);
     setIsAuthenticated(true);
     setUserEmail(email);
   };
 
   const logout = () => {
-    localStorage.removeItem('token');
+    logoutUser().catch((err) => console.error('Failed to revoke session:', err));
     localStorage.removeItem('userEmail');
     setIsAuthenticated(false);
     setUserEmail(null);
@@ -1,5 +1,5 @@
 import React, { useState, FormEvent } from 'react';
-import { Link, useNavigate } from 'react-router-dom';
+import { Link } from 'react-router-dom';
 import { forgotPassword } from '../../api/Auth';
 import './ForgotPassword.css';
 
@@ -7,7 +7,6 @@ function ForgotPassword() {
   const [email, setEmail] = useState<string>('');
   const [message, setMessage] = useState<string>('');
   const [error, setError] = useState<string>('');
-  const navigate = useNavigate();
 
   const handleSubmit = async (e: FormEvent<HTMLFormElement>) => {
     e.preventDefault();
@@ -16,11 +15,7 @@ function ForgotPassword() {
 
     try {
       await forgotPassword(email);
-      setMessage('Password reset instructions have been sent to your email.');
-      // Redirect to reset-password page after a short delay
-      setTimeout(() => {
-        navigate('/reset-password', { state: { email } });
-      }, 2000);
+      setMessage('If an account exists for this email, a password reset link has been sent.');
     } catch (err) {
       setError('Failed to process your request. Please try again.');
     }
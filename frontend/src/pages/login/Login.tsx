@@ -1,13 +1,15 @@
 import React, { useState, FormEvent, useEffect } from 'react';
 import { useNavigate, useLocation, Link } from 'react-router-dom';
 import { useAuth } from '../../contexts/AuthContext';
-import { loginUser } from '../../api/Auth';
+import { loginUser, loginMFA, startSSO } from '../../api/Auth';
 import './Login.css';
 
 function Login() {
   const [email, setEmail] = useState<string>('');
   const [password, setPassword] = useState<string>('');
   const [error, setError] = useState<string>('');
+  const [mfaToken, setMFAToken] = useState<string>('');
+  const [code, setCode] = useState<string>('');
   const { login, isAuthenticated } = useAuth();
   const navigate = useNavigate();
   const location = useLocation();
@@ -24,11 +26,39 @@ function Login() {
     setError('');
 
     try {
-      const token = await loginUser({ email, password });
-      login(token, email);
+      const response = await loginUser({ email, password });
+      if ('mfa_required' in response) {
+        setMFAToken(response.mfa_token);
+        return;
+      }
+      login(response, email);
       navigate('/');
     } catch (err) {
-      setError('Invalid email or password');
+      const message = err instanceof Error ? err.message : '';
+      setError(message.startsWith('Too many') ? message : 'Invalid email or password');
+    }
+  };
+
+  const handleMFA = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError('');
+
+    try {
+      const tokens = await loginMFA(mfaToken, code);
+      login(tokens, email);
+      navigate('/');
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Invalid two-factor code');
+    }
+  };
+
+  const handleSSO = async () => {
+    setError('');
+
+    try {
+      window.location.href = await startSSO();
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Single sign-on is unavailable');
     }
   };
 
@@ -41,6 +71,23 @@ function Login() {
       <div className="login-container">
         <h1>Login</h1>
         {error && <p className="error-message">{error}</p>}
+        {mfaToken ? (
+        <form onSubmit={handleMFA} className="login-form">
+          <div className="form-group">
+            <label htmlFor="code">Authentication code:</label>
+            <input
+              type="text"
+              id="code"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              required
+            />
+          </div>
+          <button type="submit" className="login-button">Verify</button>
+        </form>
+        ) : (
         <form onSubmit={handleLogin} className="login-form">
           <div className="form-group">
             <label htmlFor="email">Email:</label>
@@ -64,6 +111,12 @@ function Login() {
           </div>
           <button type="submit" className="login-button">Login</button>
         </form>
+        )}
+        {!mfaToken && (
+          <button type="button" className="login-button sso-button" onClick={handleSSO}>
+            Sign in with SSO
+          </button>
+        )}
         <p className="forgot-password-link">
           <Link to="/forgot-password">Forgot Password?</Link>
         </p>
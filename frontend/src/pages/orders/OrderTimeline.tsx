@@ -1,9 +1,9 @@
 import React from 'react';
 import './OrderTimeline.css';
-import { TimelineEvent } from '../../api/Order';
+import { OrderStatus, TimelineEvent } from '../../api/Order';
 
 interface OrderTimelineProps {
-  status: 'Created' | 'Confirmed' | 'Delivered' | 'Cancelled';
+  status: OrderStatus;
   timeline: TimelineEvent[];
 }
 
@@ -17,7 +17,7 @@ const OrderTimeline: React.FC<OrderTimelineProps> = ({ status, timeline }) => {
       {sortedTimeline.map((event, index) => {
         const isCreated = event.name === "Created";
         const isFailed = event.name === "Payment Failed";
-        const isCancelled = event.name === "Cancelled";
+        const isCancelled = event.name === "Cancelled" || event.name === "Expired";
         const isDelivered = event.name === "Delivered";
         const isPaymentCompleted = event.name === "Payment Completed";
         const isCurrent = event.name === status;
@@ -1,12 +1,30 @@
-import React, { useState, useEffect } from 'react';
-import { getOrders, cancelOrder, initiatePayment, Order } from '../../api/Order';
+import React, { useState, useEffect, useRef } from 'react';
+import { getOrders, cancelOrder, initiatePayment, getPayment, Order, Payment } from '../../api/Order';
 import OrderTimeline from './OrderTimeline';
 import './Orders.css';
 
+const PAYMENT_POLL_INTERVAL_MS = 2000;
+const PAYMENT_POLL_ATTEMPTS = 15;
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+// waitForPayment polls a pending payment until the gateway decides, or gives up
+const waitForPayment = async (payment: Payment): Promise<Payment> => {
+  for (let attempt = 0; payment.status === 'Pending' && attempt < PAYMENT_POLL_ATTEMPTS; attempt++) {
+    await sleep(PAYMENT_POLL_INTERVAL_MS);
+    payment = await getPayment(payment.id);
+  }
+  return payment;
+};
+
 const Orders: React.FC = () => {
   const [orders, setOrders] = useState<Order[]>([]);
   const [loading, setLoading] = useState<boolean>(true);
   const [error, setError] = useState<string | null>(null);
+  const [payingOrderId, setPayingOrderId] = useState<string | null>(null);
+  // A payment attempt keeps its key until it gets an answer, so that a retry
+  // after a network error can't charge twice
+  const paymentKeys = useRef<Record<string, string>>({});
 
   useEffect(() => {
     fetchOrders();
@@ -34,12 +52,29 @@ const Orders: React.FC = () => {
   };
 
   const handlePayOrder = async (orderId: string, amount: number) => {
+    if (payingOrderId) {
+      return;
+    }
+    const key = paymentKeys.current[orderId] || crypto.randomUUID();
+    paymentKeys.current[orderId] = key;
+    setPayingOrderId(orderId);
     try {
-      await initiatePayment(orderId, amount);
+      const payment = await initiatePayment(orderId, amount, key);
+      delete paymentKeys.current[orderId];
+      const settled = await waitForPayment(payment);
+      if (settled.status === 'Failed') {
+        setError(`Payment failed: ${settled.failure_reason || 'please try again later.'}`);
+      }
       // Refresh the orders list after payment initiation
       fetchOrders();
     } catch (err) {
+      // Network errors leave the outcome unknown; any answer ends the attempt
+      if (!(err instanceof TypeError)) {
+        delete paymentKeys.current[orderId];
+      }
       setError('Failed to initiate payment. Please try again later.');
+    } finally {
+      setPayingOrderId(null);
     }
   };
 
@@ -62,15 +97,20 @@ const Orders: React.FC = () => {
             <div key={order.id} className="order-item">
               <div className="order-details">
                 <p>Order ID: {order.id}</p>
-                <p>Product: {order.product.name}</p>
-                <p>Price: ${order.product.price.toFixed(2)}</p>
-                <p>Quantity: {order.quantity}</p>
+                {order.items.map((item) => (
+                  <p key={item.product.id}>
+                    {item.product.name}: {item.quantity} x ${item.product.price.toFixed(2)} = ${item.subtotal.toFixed(2)}
+                  </p>
+                ))}
                 <p>Total Amount: ${order.total_amount.toFixed(2)}</p>
+                {order.refunded_amount ? <p>Refunded: ${order.refunded_amount.toFixed(2)}</p> : null}
                 <p>Status: {order.status}</p>
                 {order.status === 'Created' && (
                   <div className="order-actions">
                     <button onClick={() => handleCancelOrder(order.id)}>Cancel Order</button>
-                    <button onClick={() => handlePayOrder(order.id, order.total_amount)}>Pay Now</button>
+                    <button onClick={() => handlePayOrder(order.id, order.total_amount)} disabled={payingOrderId !== null}>
+                      {payingOrderId === order.id ? 'Paying...' : 'Pay Now'}
+                    </button>
                   </div>
                 )}
               </div>
@@ -52,9 +52,7 @@ const Products: React.FC = () => {
     if (selectedProduct && userEmail) {
       try {
         const orderData = {
-          customer_id: userEmail,
-          product_id: selectedProduct.id,
-          quantity: quantity
+          items: [{ product_id: selectedProduct.id, quantity: quantity }]
         };
         await createOrder(orderData);
         console.log(`Ordered ${quantity} of ${selectedProduct.name}`);
@@ -67,7 +65,8 @@ const Products: React.FC = () => {
         handleClose();
       } catch (error) {
         console.error('Failed to create order:', error);
-        setError('Failed to create order. Please try again.');
+        const message = error instanceof Error ? error.message : '';
+        setError(message.startsWith('Please verify') ? message : 'Failed to create order. Please try again.');
       }
     } else if (!userEmail) {
       setError('You must be logged in to place an order.');
@@ -23,7 +23,7 @@ function Register() {
 
     try {
       await registerUser({ email, password });
-      setSuccess('Registration successful! You can now log in.');
+      setSuccess('Registration successful! Check your email to verify your address, then log in.');
       setTimeout(() => navigate('/login'), 3000);
     } catch (err) {
       setError('Registration failed. Please try again.');
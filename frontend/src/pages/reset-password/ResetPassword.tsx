@@ -1,24 +1,23 @@
 import React, { useState, FormEvent, useEffect } from 'react';
-import { useNavigate, Link, useLocation } from 'react-router-dom';
+import { useNavigate, Link, useSearchParams } from 'react-router-dom';
 import { resetPassword } from '../../api/Auth';
 import './ResetPassword.css';
 
 function ResetPassword() {
-  const [email, setEmail] = useState<string>('');
-  const [resetToken, setResetToken] = useState<string>('');
+  const [searchParams] = useSearchParams();
+  const tokenFromLink = searchParams.get('token');
+  const [resetToken, setResetToken] = useState<string>(tokenFromLink || '');
   const [newPassword, setNewPassword] = useState<string>('');
   const [confirmPassword, setConfirmPassword] = useState<string>('');
   const [message, setMessage] = useState<string>('');
   const [error, setError] = useState<string>('');
   const navigate = useNavigate();
-  const location = useLocation();
 
   useEffect(() => {
-    const state = location.state as { email?: string };
-    if (state && state.email) {
-      setEmail(state.email);
+    if (tokenFromLink) {
+      setResetToken(tokenFromLink);
     }
-  }, [location]);
+  }, [tokenFromLink]);
 
   const handleSubmit = async (e: FormEvent<HTMLFormElement>) => {
     e.preventDefault();
@@ -31,11 +30,11 @@ function ResetPassword() {
     }
 
     try {
-      await resetPassword(email, resetToken, newPassword);
-      setMessage('Password has been reset successfully.');
+      await resetPassword(resetToken, newPassword);
+      setMessage('Password has been reset successfully. Please log in again on all your devices.');
       setTimeout(() => navigate('/login'), 3000);
     } catch (err) {
-      setError('Failed to reset password. Please try again.');
+      setError('This reset link is invalid or has expired. Please request a new one.');
     }
   };
 
@@ -46,27 +45,18 @@ function ResetPassword() {
         {error && <p className="error-message">{error}</p>}
         {message && <p className="success-message">{message}</p>}
         <form onSubmit={handleSubmit} className="reset-password-form">
-          <div className="form-group">
-            <label htmlFor="email">Email:</label>
-            <input
-              type="email"
-              id="email"
-              value={email}
-              onChange={(e) => setEmail(e.target.value)}
-              required
-              readOnly={!!location.state}
-            />
-          </div>
-          <div className="form-group">
-            <label htmlFor="resetToken">Reset Token:</label>
-            <input
-              type="text"
-              id="resetToken"
-              value={resetToken}
-              onChange={(e) => setResetToken(e.target.value)}
-              required
-            />
-          </div>
+          {!tokenFromLink && (
+            <div className="form-group">
+              <label htmlFor="resetToken">Reset Token:</label>
+              <input
+                type="text"
+                id="resetToken"
+                value={resetToken}
+                onChange={(e) => setResetToken(e.target.value)}
+                required
+              />
+            </div>
+          )}
           <div className="form-group">
             <label htmlFor="newPassword">New Password:</label>
             <input
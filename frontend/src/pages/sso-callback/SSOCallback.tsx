@@ -0,0 +1,90 @@
+import React, { useState, useEffect, useRef, FormEvent } from 'react';
+import { Link, useNavigate, useSearchParams } from 'react-router-dom';
+import { useAuth } from '../../contexts/AuthContext';
+import { completeSSO, emailFromToken, loginMFA, LoginResponse } from '../../api/Auth';
+import '../login/Login.css';
+
+function SSOCallback() {
+  const [searchParams] = useSearchParams();
+  const [mfaToken, setMFAToken] = useState<string>('');
+  const [code, setCode] = useState<string>('');
+  const [error, setError] = useState<string>('');
+  const { login } = useAuth();
+  const navigate = useNavigate();
+  // The code can only be exchanged once, even if the effect runs twice
+  const started = useRef<boolean>(false);
+
+  const finish = (tokens: LoginResponse) => {
+    login(tokens, emailFromToken(tokens.token));
+    navigate('/', { replace: true });
+  };
+
+  useEffect(() => {
+    if (started.current) {
+      return;
+    }
+    started.current = true;
+
+    const providerError = searchParams.get('error');
+    const authCode = searchParams.get('code');
+    const state = searchParams.get('state');
+    if (providerError || !authCode || !state) {
+      setError(searchParams.get('error_description') || 'Single sign-on was cancelled');
+      return;
+    }
+
+    completeSSO(authCode, state)
+      .then((response) => {
+        if ('mfa_required' in response) {
+          setMFAToken(response.mfa_token);
+          return;
+        }
+        finish(response);
+      })
+      .catch((err) => setError(err instanceof Error ? err.message : 'Single sign-on failed'));
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [searchParams]);
+
+  const handleMFA = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError('');
+
+    try {
+      finish(await loginMFA(mfaToken, code));
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Invalid two-factor code');
+    }
+  };
+
+  return (
+    <div className="login-page">
+      <div className="login-container">
+        <h1>Single Sign-On</h1>
+        {error && <p className="error-message">{error}</p>}
+        {!error && !mfaToken && <p>Signing you in...</p>}
+        {mfaToken && (
+          <form onSubmit={handleMFA} className="login-form">
+            <div className="form-group">
+              <label htmlFor="code">Authentication code:</label>
+              <input
+                type="text"
+                id="code"
+                inputMode="numeric"
+                autoComplete="one-time-code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                required
+              />
+            </div>
+            <button type="submit" className="login-button">Verify</button>
+          </form>
+        )}
+        <p className="register-link">
+          <Link to="/login">Back to login</Link>
+        </p>
+      </div>
+    </div>
+  );
+}
+
+export default SSOCallback;
@@ -0,0 +1,3 @@
+import SSOCallback from './SSOCallback';
+
+export default SSOCallback;
@@ -0,0 +1,68 @@
+import React, { useState, useEffect, FormEvent } from 'react';
+import { Link, useSearchParams } from 'react-router-dom';
+import { verifyEmail, resendVerification } from '../../api/Auth';
+import './VerifyEmail.css';
+
+function VerifyEmail() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token');
+  const [verifying, setVerifying] = useState<boolean>(!!token);
+  const [email, setEmail] = useState<string>('');
+  const [message, setMessage] = useState<string>('');
+  const [error, setError] = useState<string>('');
+
+  useEffect(() => {
+    if (!token) {
+      return;
+    }
+
+    verifyEmail(token)
+      .then(() => setMessage('Your email has been verified. You can now place orders.'))
+      .catch(() => setError('This verification link is invalid or has expired. Request a new one below.'))
+      .finally(() => setVerifying(false));
+  }, [token]);
+
+  const handleResend = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError('');
+    setMessage('');
+
+    try {
+      await resendVerification(email);
+      setMessage('If an unverified account exists for this email, a verification email has been sent.');
+    } catch (err) {
+      setError('Failed to process your request. Please try again.');
+    }
+  };
+
+  return (
+    <div className="verify-email-page">
+      <div className="verify-email-container">
+        <h1>Verify Email</h1>
+        {verifying && <p className="info-message">Verifying your email...</p>}
+        {error && <p className="error-message">{error}</p>}
+        {message && <p className="success-message">{message}</p>}
+        {!verifying && !message && (
+          <form onSubmit={handleResend} className="verify-email-form">
+            <div className="form-group">
+              <label htmlFor="email">Email:</label>
+              <input
+                type="email"
+                id="email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+              />
+            </div>
+            <button type="submit" className="verify-email-button">Send Verification Email</button>
+          </form>
+        )}
+        <p className="login-link">
+          <Link to="/login">Back to login</Link>
+        </p>
+      </div>
+    </div>
+  );
+}
+
+export default VerifyEmail;
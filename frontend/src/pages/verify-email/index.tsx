@@ -0,0 +1,3 @@
+import VerifyEmail from './VerifyEmail';
+
+export default VerifyEmail;
@@ -2,6 +2,48 @@ provider "aws" {
   region = "us-east-1" 
 }
 
+variable "jwt_keys" {
+  description = "Access token keys of backend-order, as kid:ALG:value separated by commas. RS256 and EdDSA keys use env:NAME values naming an entry of jwt_key_secrets"
+  type        = string
+  sensitive   = true
+}
+
+variable "jwt_key_secrets" {
+  description = "PEM keys referenced by jwt_keys, as environment variable name to the ARN of the Secrets Manager secret or SSM parameter holding the key"
+  type        = map(string)
+  default     = {}
+}
+
+variable "jwt_signing_key_id" {
+  description = "kid of the key backend-order signs access tokens with"
+  type        = string
+}
+
+variable "oidc_issuer" {
+  description = "Issuer URL of the identity provider for single sign-on, empty to disable it"
+  type        = string
+  default     = ""
+}
+
+variable "oidc_client_id" {
+  description = "Client ID of backend-order at the identity provider"
+  type        = string
+  default     = ""
+}
+
+variable "oidc_client_secret" {
+  description = "Client secret of backend-order at the identity provider"
+  type        = string
+  default     = ""
+  sensitive   = true
+}
+
+variable "oidc_group_roles" {
+  description = "Identity provider groups mapped to roles, as group=role separated by commas"
+  type        = string
+  default     = ""
+}
+
 # Use the default VPC
 data "aws_vpc" "default" {
   default = true
@@ -81,6 +123,44 @@ resource "aws_ecs_task_definition" "backend_order" {
       {
         name  = "API_SECRET_KEY"
         value = "secret-key-for-backend-call"
+      },
+      {
+        name  = "JWT_KEYS"
+        value = var.jwt_keys
+      },
+      {
+        name  = "JWT_SIGNING_KEY_ID"
+        value = var.jwt_signing_key_id
+      },
+      {
+        name  = "TRUSTED_PROXIES"
+        value = data.aws_vpc.default.cidr_block
+      },
+      {
+        name  = "FRONTEND_URL"
+        value = "https://${aws_cloudfront_distribution.frontend.domain_name}"
+      },
+      {
+        name  = "OIDC_ISSUER"
+        value = var.oidc_issuer
+      },
+      {
+        name  = "OIDC_CLIENT_ID"
+        value = var.oidc_client_id
+      },
+      {
+        name  = "OIDC_CLIENT_SECRET"
+        value = var.oidc_client_secret
+      },
+      {
+        name  = "OIDC_GROUP_ROLES"
+        value = var.oidc_group_roles
+      }
+    ]
+    secrets = [
+      for name, arn in var.jwt_key_secrets : {
+        name      = name
+        valueFrom = arn
       }
     ]
     logConfiguration = {
@@ -133,6 +213,10 @@ resource "aws_ecs_task_definition" "backend_payment" {
       {
         name  = "API_SECRET_KEY"
         value = "secret-key-for-backend-call"
+      },
+      {
+        name  = "PAYMENT_GATEWAY"
+        value = "simulator"
       }
     ]
     logConfiguration = {
@@ -260,6 +344,24 @@ resource "aws_iam_role_policy_attachment" "ecs_task_execution_ecr_policy" {
   policy_arn = "arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"
 }
 
+# Lets ECS read the token signing keys injected into backend-order
+resource "aws_iam_role_policy" "ecs_task_execution_jwt_keys" {
+  count = length(var.jwt_key_secrets) > 0 ? 1 : 0
+  name  = "cursor-test-ecs-jwt-keys"
+  role  = aws_iam_role.ecs_task_execution_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action   = ["secretsmanager:GetSecretValue", "ssm:GetParameters"]
+        Effect   = "Allow"
+        Resource = values(var.jwt_key_secrets)
+      }
+    ]
+  })
+}
+
 resource "aws_iam_role_policy_attachment" "ecs_task_execution_role_policy_logs" {
   role       = aws_iam_role.ecs_task_execution_role.name
   policy_arn = "arn:aws:iam::aws:policy/CloudWatchLogsFullAccess"